@@ -3,6 +3,10 @@ package client
 import (
 	"log"
 	"net/rpc"
+	"strings"
+	"time"
+
+	"github.com/lengzhao/database/smt"
 )
 
 // Client client
@@ -30,6 +34,14 @@ type SetWithFlagArgs struct {
 	Value  []byte
 }
 
+// DeleteWithFlagArgs DeleteWithFlag接口的入参
+type DeleteWithFlagArgs struct {
+	Chain  uint64
+	Flag   []byte
+	TbName []byte
+	Key    []byte
+}
+
 // GetArgs Get接口的入参
 type GetArgs struct {
 	Chain  uint64
@@ -43,6 +55,261 @@ type FlagArgs struct {
 	Flag  []byte
 }
 
+// OpenFlagOnArgs OpenFlagOn接口的入参
+type OpenFlagOnArgs struct {
+	Chain  uint64
+	Parent []byte
+	Flag   []byte
+}
+
+// GetWithFlagArgs GetWithFlag接口的入参
+type GetWithFlagArgs struct {
+	Chain  uint64
+	Flag   []byte
+	TbName []byte
+	Key    []byte
+}
+
+// SavepointArgs savepoint操作的参数
+type SavepointArgs struct {
+	Chain uint64
+	Flag  []byte
+	ID    uint64
+}
+
+// ScanArgs Scan接口的入参
+type ScanArgs struct {
+	Chain  uint64
+	TbName []byte
+	Start  []byte
+	End    []byte
+	Limit  int
+	// Reverse scan in the descending order of key
+	Reverse bool
+	// Flag 不为空时，获取该标志下看到的数据
+	Flag []byte
+}
+
+// ScanPrefixArgs ScanPrefix接口的入参
+type ScanPrefixArgs struct {
+	Chain  uint64
+	TbName []byte
+	Prefix []byte
+	Limit  int
+}
+
+// KV key and value
+type KV struct {
+	Key   []byte
+	Value []byte
+}
+
+// ScanReply Scan接口的返回值
+type ScanReply struct {
+	Items []KV
+	Next  []byte
+}
+
+// FlagChangesArgs GetFlagChanges接口的入参
+type FlagChangesArgs struct {
+	Chain  uint64
+	Flag   []byte
+	Offset int
+	Limit  int
+}
+
+// Change 标志修改的数据，空值表示key不存在
+type Change struct {
+	TbName   []byte
+	Key      []byte
+	PreValue []byte
+	Value    []byte
+}
+
+// FlagChangesReply GetFlagChanges接口的返回值
+type FlagChangesReply struct {
+	Items []Change
+	Next  int
+}
+
+// ChangesBetweenArgs ChangesBetween接口的入参
+type ChangesBetweenArgs struct {
+	Chain    uint64
+	FromFlag []byte
+	ToFlag   []byte
+	// Start 第一条变化的key，为上一页的next，nil表示从头开始
+	Start *TableKey
+	Limit int
+}
+
+// ChangesBetweenReply ChangesBetween接口的返回值
+type ChangesBetweenReply struct {
+	Items []Change
+	// Next 下一页第一条变化的key，nil表示没有更多数据
+	Next *TableKey
+}
+
+// Proof 数据的证明，用VerifyProof验证
+type Proof struct {
+	Flag          []byte
+	Root          []byte
+	Siblings      [][]byte
+	LeafPath      []byte
+	LeafValueHash []byte
+}
+
+// ProofReply GetWithProof接口的返回值
+type ProofReply struct {
+	Value []byte
+	Proof Proof
+}
+
+// VerifyChainReply VerifyChain接口的返回值
+type VerifyChainReply struct {
+	// Checked 检查的标志数量
+	Checked int
+	// Mismatch 第一个hash不一致的标志，nil表示全部一致
+	Mismatch []byte
+}
+
+// TableMode 表的模式，表中的数据只能都携带标志写入，或都不携带标志写入
+type TableMode byte
+
+// 表的模式
+const (
+	// TableModeNone 未确定模式
+	TableModeNone TableMode = iota
+	// TableModeUnflagged 使用Set/Delete写入
+	TableModeUnflagged
+	// TableModeFlagged 使用SetWithFlag/DeleteWithFlag写入
+	TableModeFlagged
+)
+
+// tableModeError 表模式错误的信息前缀，与服务端的TableModeError一致
+const tableModeError = "wrong table mode"
+
+// IsTableModeError 判断err是否是表模式错误(用另一种模式写表)，RPC返回的错误只保留错误信息
+func IsTableModeError(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), tableModeError)
+}
+
+// CreateTableArgs CreateTable接口的入参
+type CreateTableArgs struct {
+	Chain  uint64
+	TbName []byte
+	Mode   TableMode
+}
+
+// TableStat 表的统计信息(已提交的数据)
+type TableStat struct {
+	// Mode 表的模式
+	Mode TableMode
+	// Keys key的数量
+	Keys uint64
+	// Bytes key和value的总大小
+	Bytes uint64
+	// Flagged 表中有携带标志写入的数据，只能携带标志删除
+	Flagged bool
+}
+
+// BatchItem 批量写入的数据，value为空时删除数据
+type BatchItem struct {
+	TbName []byte
+	Key    []byte
+	Value  []byte
+}
+
+// SetBatchArgs SetBatch接口的入参
+type SetBatchArgs struct {
+	Chain uint64
+	// Flag SetWithFlagBatch的标志
+	Flag  []byte
+	Items []BatchItem
+}
+
+// TableKey 表中的key
+type TableKey struct {
+	TbName []byte
+	Key    []byte
+}
+
+// GetMultiArgs GetMulti接口的入参
+type GetMultiArgs struct {
+	Chain uint64
+	Keys  []TableKey
+}
+
+// SetWithTTLArgs SetWithTTL接口的入参
+type SetWithTTLArgs struct {
+	Chain  uint64
+	TbName []byte
+	Key    []byte
+	Value  []byte
+	TTL    time.Duration
+}
+
+// CompareAndSetArgs CompareAndSet接口的入参
+type CompareAndSetArgs struct {
+	Chain    uint64
+	TbName   []byte
+	Key      []byte
+	Expected []byte
+	Value    []byte
+}
+
+// 服务端内置的合并操作
+const (
+	// MergeAppend 把operand追加到原值后面
+	MergeAppend = "append"
+	// MergeMax 保留原值与operand中较大的一个(按字节比较)
+	MergeMax = "max"
+	// MergeAdd 计数器(8字节BigEndian uint64，空值为0)加上operand(8字节BigEndian int64)，下溢或溢出时返回错误
+	MergeAdd = "add"
+)
+
+// MergeArgs Merge接口的入参
+type MergeArgs struct {
+	Chain uint64
+	// Flag MergeWithFlag的标志
+	Flag    []byte
+	TbName  []byte
+	Key     []byte
+	Op      string
+	Operand []byte
+}
+
+// IncrementArgs Increment接口的入参
+type IncrementArgs struct {
+	Chain uint64
+	// Flag IncrementWithFlag的标志
+	Flag   []byte
+	TbName []byte
+	Key    []byte
+	Delta  int64
+}
+
+// GetMultiReply GetMulti接口的返回值
+type GetMultiReply struct {
+	Values [][]byte
+	Found  []bool
+}
+
+// RecoveryReport 数据库打开时的恢复结果
+type RecoveryReport struct {
+	Journal       string
+	JournalFlag   []byte
+	LastFlag      []byte
+	CommittedFlag []byte
+	RolledBack    [][]byte
+	RemovedFiles  []string
+}
+
+// StatusReply Status接口的返回值
+type StatusReply struct {
+	LastFlag []byte
+	Recovery RecoveryReport
+}
+
 // New new c.client
 func New(addrType, serverAddr string, clientNum int) *Client {
 	out := new(Client)
@@ -102,8 +369,37 @@ func (c *Client) OpenFlag(chain uint64, flag []byte) error {
 	return err
 }
 
-// GetLastFlag 获取最后一个标志
-func (c *Client) GetLastFlag(chain uint64) []byte {
+// OpenFlagOn 在已开启的标志(parent)上开启标志，能看到parent的数据，parent提交后才能提交
+func (c *Client) OpenFlagOn(chain uint64, parent, flag []byte) error {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return err
+		}
+	}
+
+	args := OpenFlagOnArgs{chain, parent, flag}
+	var reply bool
+	err = c.client[id].Call("TDb.OpenFlagOn", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.OpenFlagOn:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return err
+	}
+
+	return err
+}
+
+// GetOpenedFlags 获取已开启的标志，按开启的顺序
+func (c *Client) GetOpenedFlags(chain uint64) [][]byte {
 	var err error
 	id, ok := <-c.lock
 	if !ok {
@@ -118,19 +414,20 @@ func (c *Client) GetLastFlag(chain uint64) []byte {
 		}
 	}
 
-	var reply = make([]byte, 100)
-	err = c.client[id].Call("TDb.GetLastFlag", &chain, &reply)
+	var reply [][]byte
+	err = c.client[id].Call("TDb.GetOpenedFlags", &chain, &reply)
 	if err != nil {
-		log.Println("fail to GetLastFlag:", c.addrType, c.dbServer, err)
+		log.Println("fail to TDb.GetOpenedFlags:", c.addrType, c.dbServer, err)
 		c.client[id].Close()
 		c.client[id] = nil
 		return nil
 	}
+
 	return reply
 }
 
-// Commit 提交，将数据写入磁盘，标志清除
-func (c *Client) Commit(chain uint64, flag []byte) error {
+// GetWithFlag 获取标志下看到的数据
+func (c *Client) GetWithFlag(chain uint64, flag, tbName, key []byte) ([]byte, error) {
 	var err error
 	id, ok := <-c.lock
 	if !ok {
@@ -141,25 +438,25 @@ func (c *Client) Commit(chain uint64, flag []byte) error {
 		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
 		if err != nil {
 			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
-			return err
+			return nil, err
 		}
 	}
 
-	args := FlagArgs{chain, flag}
-	var reply bool
-	err = c.client[id].Call("TDb.CommitFlag", &args, &reply)
+	args := GetWithFlagArgs{chain, flag, tbName, key}
+	var reply []byte
+	err = c.client[id].Call("TDb.GetWithFlag", &args, &reply)
 	if err != nil {
-		log.Println("fail to CommitFlag:", c.addrType, c.dbServer, err)
+		log.Println("fail to TDb.GetWithFlag:", c.addrType, c.dbServer, err)
 		c.client[id].Close()
 		c.client[id] = nil
-		return err
+		return nil, err
 	}
 
-	return err
+	return reply, nil
 }
 
-// Cancel 取消提交，将数据回滚
-func (c *Client) Cancel(chain uint64, flag []byte) error {
+// ExistWithFlag 标志下数据是否存在
+func (c *Client) ExistWithFlag(chain uint64, flag, tbName, key []byte) (bool, error) {
 	var err error
 	id, ok := <-c.lock
 	if !ok {
@@ -170,25 +467,25 @@ func (c *Client) Cancel(chain uint64, flag []byte) error {
 		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
 		if err != nil {
 			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
-			return err
+			return false, err
 		}
 	}
 
-	args := FlagArgs{chain, flag}
+	args := GetWithFlagArgs{chain, flag, tbName, key}
 	var reply bool
-	err = c.client[id].Call("TDb.CancelFlag", &args, &reply)
+	err = c.client[id].Call("TDb.ExistWithFlag", &args, &reply)
 	if err != nil {
-		//log.Println("fail to CancelFlag:", err)
+		log.Println("fail to TDb.ExistWithFlag:", c.addrType, c.dbServer, err)
 		c.client[id].Close()
 		c.client[id] = nil
-		return err
+		return false, err
 	}
 
-	return err
+	return reply, nil
 }
 
-// Rollback 将指定标志之后的所有操作回滚，要求当前没有开启标志
-func (c *Client) Rollback(chain uint64, flag []byte) error {
+// ScanWithFlag 获取标志下看到的[start,end)范围内的数据，同Scan/ScanReverse
+func (c *Client) ScanWithFlag(chain uint64, flag, tbName, start, end []byte, limit int, reverse bool) ([]KV, []byte, error) {
 	var err error
 	id, ok := <-c.lock
 	if !ok {
@@ -199,25 +496,79 @@ func (c *Client) Rollback(chain uint64, flag []byte) error {
 		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
 		if err != nil {
 			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
-			return err
+			return nil, nil, err
 		}
 	}
 
-	args := FlagArgs{chain, flag}
-	var reply bool
-	err = c.client[id].Call("TDb.Rollback", &args, &reply)
+	args := ScanArgs{chain, tbName, start, end, limit, reverse, flag}
+	var reply ScanReply
+	err = c.client[id].Call("TDb.Scan", &args, &reply)
 	if err != nil {
-		log.Println("fail to Rollback:", c.addrType, c.dbServer, err)
+		log.Println("fail to TDb.Scan:", c.addrType, c.dbServer, err)
 		c.client[id].Close()
 		c.client[id] = nil
-		return err
+		return nil, nil, err
 	}
 
-	return err
+	return reply.Items, reply.Next, nil
 }
 
-// Set 存储数据，不携带标签，不会被回滚,tbName中的数据都别用SetWithFlag写，否则可能导致数据混乱
-func (c *Client) Set(chain uint64, tbName, key, value []byte) error {
+// GetLastFlag 获取最后一个标志，存在打开的标志时是最后打开的标志(Get/Exist/Scan读取它的数据)
+func (c *Client) GetLastFlag(chain uint64) []byte {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil
+		}
+	}
+
+	var reply = make([]byte, 100)
+	err = c.client[id].Call("TDb.GetLastFlag", &chain, &reply)
+	if err != nil {
+		log.Println("fail to GetLastFlag:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil
+	}
+	return reply
+}
+
+// Status 获取最后一个标志和数据库打开时的恢复结果
+func (c *Client) Status(chain uint64) (*StatusReply, error) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil, err
+		}
+	}
+
+	reply := new(StatusReply)
+	err = c.client[id].Call("TDb.Status", &chain, reply)
+	if err != nil {
+		log.Println("fail to Status:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil, err
+	}
+	return reply, nil
+}
+
+// Commit 提交，将数据写入磁盘，标志清除
+func (c *Client) Commit(chain uint64, flag []byte) error {
 	var err error
 	id, ok := <-c.lock
 	if !ok {
@@ -232,11 +583,11 @@ func (c *Client) Set(chain uint64, tbName, key, value []byte) error {
 		}
 	}
 
-	args := SetArgs{chain, tbName, key, value}
+	args := FlagArgs{chain, flag}
 	var reply bool
-	err = c.client[id].Call("TDb.Set", &args, &reply)
+	err = c.client[id].Call("TDb.CommitFlag", &args, &reply)
 	if err != nil {
-		log.Println("fail to TDb.Set:", c.addrType, c.dbServer, err)
+		log.Println("fail to CommitFlag:", c.addrType, c.dbServer, err)
 		c.client[id].Close()
 		c.client[id] = nil
 		return err
@@ -245,10 +596,8 @@ func (c *Client) Set(chain uint64, tbName, key, value []byte) error {
 	return err
 }
 
-// SetWithFlag 写入数据，标志仅仅是一个标志，方便数据回滚
-// 每个flag都有对应的historyDb文件，用于记录tbName.key的前一个标签记录位置
-// 同时记录本标签最终设置的值，方便回滚
-func (c *Client) SetWithFlag(chain uint64, flag, tbName, key, value []byte) error {
+// Cancel 取消提交，将数据回滚
+func (c *Client) Cancel(chain uint64, flag []byte) error {
 	var err error
 	id, ok := <-c.lock
 	if !ok {
@@ -263,11 +612,11 @@ func (c *Client) SetWithFlag(chain uint64, flag, tbName, key, value []byte) erro
 		}
 	}
 
-	args := SetWithFlagArgs{chain, flag, tbName, key, value}
+	args := FlagArgs{chain, flag}
 	var reply bool
-	err = c.client[id].Call("TDb.SetWithFlag", &args, &reply)
+	err = c.client[id].Call("TDb.CancelFlag", &args, &reply)
 	if err != nil {
-		log.Println("fail to TDb.SetWithFlag:", c.addrType, c.dbServer, err)
+		//log.Println("fail to CancelFlag:", err)
 		c.client[id].Close()
 		c.client[id] = nil
 		return err
@@ -276,8 +625,8 @@ func (c *Client) SetWithFlag(chain uint64, flag, tbName, key, value []byte) erro
 	return err
 }
 
-// Get 获取数据
-func (c *Client) Get(chain uint64, tbName, key []byte) []byte {
+// Savepoint 在开启的标志中创建保存点，返回保存点的id，保存点之后的修改可以单独撤销
+func (c *Client) Savepoint(chain uint64, flag []byte) (uint64, error) {
 	var err error
 	id, ok := <-c.lock
 	if !ok {
@@ -288,25 +637,25 @@ func (c *Client) Get(chain uint64, tbName, key []byte) []byte {
 		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
 		if err != nil {
 			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
-			return nil
+			return 0, err
 		}
 	}
 
-	args := GetArgs{chain, tbName, key}
-	var reply = make([]byte, 65536)
-	err = c.client[id].Call("TDb.Get", &args, &reply)
+	args := FlagArgs{chain, flag}
+	var reply uint64
+	err = c.client[id].Call("TDb.Savepoint", &args, &reply)
 	if err != nil {
-		log.Println("fail to TDb.Get:", c.addrType, c.dbServer, err)
+		log.Println("fail to Savepoint:", c.addrType, c.dbServer, err)
 		c.client[id].Close()
 		c.client[id] = nil
-		return nil
+		return 0, err
 	}
 
-	return reply
+	return reply, nil
 }
 
-// GetNextKey get next key
-func (c *Client) GetNextKey(chain uint64, tbName, preKey []byte) []byte {
+// RollbackToSavepoint 撤销保存点之后的修改，之后创建的保存点被释放，保存点本身保留
+func (c *Client) RollbackToSavepoint(chain uint64, flag []byte, spID uint64) error {
 	var err error
 	id, ok := <-c.lock
 	if !ok {
@@ -317,21 +666,1112 @@ func (c *Client) GetNextKey(chain uint64, tbName, preKey []byte) []byte {
 		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
 		if err != nil {
 			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
-			return nil
+			return err
 		}
 	}
 
-	args := GetArgs{chain, tbName, preKey}
-	var reply = make([]byte, 500)
-	err = c.client[id].Call("TDb.GetNextKey", &args, &reply)
+	args := SavepointArgs{chain, flag, spID}
+	var reply bool
+	err = c.client[id].Call("TDb.RollbackToSavepoint", &args, &reply)
 	if err != nil {
-		log.Println("fail to TDb.GetNextKey:", c.addrType, c.dbServer, err)
+		log.Println("fail to RollbackToSavepoint:", c.addrType, c.dbServer, err)
 		c.client[id].Close()
 		c.client[id] = nil
-		return nil
+		return err
 	}
 
-	return reply
+	return err
+}
+
+// ReleaseSavepoint 释放保存点及之后创建的保存点，保留修改
+func (c *Client) ReleaseSavepoint(chain uint64, flag []byte, spID uint64) error {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return err
+		}
+	}
+
+	args := SavepointArgs{chain, flag, spID}
+	var reply bool
+	err = c.client[id].Call("TDb.ReleaseSavepoint", &args, &reply)
+	if err != nil {
+		log.Println("fail to ReleaseSavepoint:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return err
+	}
+
+	return err
+}
+
+// Rollback 将指定标志之后的所有操作回滚，要求当前没有开启标志
+func (c *Client) Rollback(chain uint64, flag []byte) error {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return err
+		}
+	}
+
+	args := FlagArgs{chain, flag}
+	var reply bool
+	err = c.client[id].Call("TDb.Rollback", &args, &reply)
+	if err != nil {
+		log.Println("fail to Rollback:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return err
+	}
+
+	return err
+}
+
+// RollbackTo 回滚指定标志之后提交的所有标志，指定标志成为最后一个标志，要求当前没有开启标志
+func (c *Client) RollbackTo(chain uint64, flag []byte) error {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return err
+		}
+	}
+
+	args := FlagArgs{chain, flag}
+	var reply bool
+	err = c.client[id].Call("TDb.RollbackTo", &args, &reply)
+	if err != nil {
+		log.Println("fail to RollbackTo:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return err
+	}
+
+	return err
+}
+
+// Set 存储数据，不携带标签，不会被回滚。表第一次写入时确定模式，用SetWithFlag写过的表会返回表模式错误
+// value为空时删除数据(空值与不存在相同)
+func (c *Client) Set(chain uint64, tbName, key, value []byte) error {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return err
+		}
+	}
+
+	args := SetArgs{chain, tbName, key, value}
+	var reply bool
+	err = c.client[id].Call("TDb.Set", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.Set:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return err
+	}
+
+	return err
+}
+
+// SetWithFlag 写入数据，标志仅仅是一个标志，方便数据回滚
+// 每个flag都有对应的historyDb文件，用于记录tbName.key的前一个标签记录位置
+// 同时记录本标签最终设置的值，方便回滚
+// value为空时删除数据(空值与不存在相同)
+func (c *Client) SetWithFlag(chain uint64, flag, tbName, key, value []byte) error {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return err
+		}
+	}
+
+	args := SetWithFlagArgs{chain, flag, tbName, key, value}
+	var reply bool
+	err = c.client[id].Call("TDb.SetWithFlag", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.SetWithFlag:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return err
+	}
+
+	return err
+}
+
+// Delete 删除数据，不携带标签，不会被回滚
+func (c *Client) Delete(chain uint64, tbName, key []byte) error {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return err
+		}
+	}
+
+	args := GetArgs{chain, tbName, key}
+	var reply bool
+	err = c.client[id].Call("TDb.Delete", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.Delete:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return err
+	}
+
+	return err
+}
+
+// DeleteWithFlag 删除数据，携带标志，可以回滚
+func (c *Client) DeleteWithFlag(chain uint64, flag, tbName, key []byte) error {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return err
+		}
+	}
+
+	args := DeleteWithFlagArgs{chain, flag, tbName, key}
+	var reply bool
+	err = c.client[id].Call("TDb.DeleteWithFlag", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.DeleteWithFlag:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return err
+	}
+
+	return err
+}
+
+// Get 获取数据，读取最后打开的标志的数据(见GetLastFlag)
+func (c *Client) Get(chain uint64, tbName, key []byte) []byte {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil
+		}
+	}
+
+	args := GetArgs{chain, tbName, key}
+	var reply = make([]byte, 65536)
+	err = c.client[id].Call("TDb.Get", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.Get:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil
+	}
+
+	return reply
+}
+
+// SetWithTTL 存储数据，ttl后过期，不携带标签，不会被回滚，只能用于未带标签的表
+// 过期的数据与不存在相同，由服务端在后台删除；再次Set/Delete会去掉ttl
+func (c *Client) SetWithTTL(chain uint64, tbName, key, value []byte, ttl time.Duration) error {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return err
+		}
+	}
+
+	args := SetWithTTLArgs{chain, tbName, key, value, ttl}
+	var reply bool
+	err = c.client[id].Call("TDb.SetWithTTL", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.SetWithTTL:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return err
+	}
+
+	return nil
+}
+
+// CompareAndSet 当前值等于expected时才写入，不携带标签，只能用于未带标签的表
+// expected为空表示数据不存在，value为空时删除数据，返回是否写入
+func (c *Client) CompareAndSet(chain uint64, tbName, key, expected, value []byte) (bool, error) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return false, err
+		}
+	}
+
+	args := CompareAndSetArgs{chain, tbName, key, expected, value}
+	var reply bool
+	err = c.client[id].Call("TDb.CompareAndSet", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.CompareAndSet:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return false, err
+	}
+
+	return reply, nil
+}
+
+// SetIfAbsent 数据不存在时才写入，不携带标签，只能用于未带标签的表，返回是否写入
+func (c *Client) SetIfAbsent(chain uint64, tbName, key, value []byte) (bool, error) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return false, err
+		}
+	}
+
+	args := SetArgs{chain, tbName, key, value}
+	var reply bool
+	err = c.client[id].Call("TDb.SetIfAbsent", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.SetIfAbsent:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return false, err
+	}
+
+	return reply, nil
+}
+
+// Merge 用服务端的合并操作op把operand合并到原值，不携带标签，不会被回滚，返回新值
+func (c *Client) Merge(chain uint64, tbName, key []byte, op string, operand []byte) ([]byte, error) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil, err
+		}
+	}
+
+	args := MergeArgs{chain, nil, tbName, key, op, operand}
+	var reply []byte
+	err = c.client[id].Call("TDb.Merge", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.Merge:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+// MergeWithFlag 用服务端的合并操作op把operand合并到原值，携带标签，可以被回滚，返回新值
+func (c *Client) MergeWithFlag(chain uint64, flag, tbName, key []byte, op string, operand []byte) ([]byte, error) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil, err
+		}
+	}
+
+	args := MergeArgs{chain, flag, tbName, key, op, operand}
+	var reply []byte
+	err = c.client[id].Call("TDb.MergeWithFlag", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.MergeWithFlag:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+// Increment 计数器(8字节BigEndian，不存在时为0)加上delta，不携带标签，不会被回滚，返回新值；下溢或溢出时返回错误
+func (c *Client) Increment(chain uint64, tbName, key []byte, delta int64) (uint64, error) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return 0, err
+		}
+	}
+
+	args := IncrementArgs{chain, nil, tbName, key, delta}
+	var reply uint64
+	err = c.client[id].Call("TDb.Increment", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.Increment:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return 0, err
+	}
+
+	return reply, nil
+}
+
+// IncrementWithFlag 计数器(8字节BigEndian，不存在时为0)加上delta，携带标签，可以被回滚，返回新值；下溢或溢出时返回错误
+func (c *Client) IncrementWithFlag(chain uint64, flag, tbName, key []byte, delta int64) (uint64, error) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return 0, err
+		}
+	}
+
+	args := IncrementArgs{chain, flag, tbName, key, delta}
+	var reply uint64
+	err = c.client[id].Call("TDb.IncrementWithFlag", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.IncrementWithFlag:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return 0, err
+	}
+
+	return reply, nil
+}
+
+// GetMulti 批量获取数据，按keys的顺序返回，found表示数据是否存在
+func (c *Client) GetMulti(chain uint64, keys []TableKey) ([][]byte, []bool, error) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil, nil, err
+		}
+	}
+
+	args := GetMultiArgs{chain, keys}
+	var reply GetMultiReply
+	err = c.client[id].Call("TDb.GetMulti", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.GetMulti:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil, nil, err
+	}
+
+	return reply.Values, reply.Found, nil
+}
+
+// GetAt 获取标志提交时的数据，标志的历史被删除(超过保留范围)时返回错误
+func (c *Client) GetAt(chain uint64, flag, tbName, key []byte) ([]byte, error) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil, err
+		}
+	}
+
+	args := GetWithFlagArgs{chain, flag, tbName, key}
+	var reply []byte
+	err = c.client[id].Call("TDb.GetAt", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.GetAt:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+// GetFlagChanges 获取已提交标志修改的数据，从offset开始最多limit条(limit<=0:不限制)
+// next为下一页的offset(没有更多数据时为0)
+func (c *Client) GetFlagChanges(chain uint64, flag []byte, offset, limit int) ([]Change, int, error) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil, 0, err
+		}
+	}
+
+	args := FlagChangesArgs{chain, flag, offset, limit}
+	var reply FlagChangesReply
+	err = c.client[id].Call("TDb.GetFlagChanges", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.GetFlagChanges:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil, 0, err
+	}
+
+	return reply.Items, reply.Next, nil
+}
+
+// ChangesBetween 获取fromFlag之后到toFlag(包含)提交的数据的净变化，从start(包含)开始最多limit条(limit<=0:不限制)
+// 多次修改的key：PreValue为fromFlag时的值，Value为最后的值，改回原值的key被忽略
+// start为nil时从第一条开始，next为下一页的start(没有更多数据时为nil)
+func (c *Client) ChangesBetween(chain uint64, fromFlag, toFlag []byte, start *TableKey, limit int) ([]Change, *TableKey, error) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil, nil, err
+		}
+	}
+
+	args := ChangesBetweenArgs{chain, fromFlag, toFlag, start, limit}
+	var reply ChangesBetweenReply
+	err = c.client[id].Call("TDb.ChangesBetween", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.ChangesBetween:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil, nil, err
+	}
+
+	return reply.Items, reply.Next, nil
+}
+
+// GetStateRoot 获取标志提交后的状态根
+func (c *Client) GetStateRoot(chain uint64, flag []byte) ([]byte, error) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil, err
+		}
+	}
+
+	args := FlagArgs{chain, flag}
+	var reply []byte
+	err = c.client[id].Call("TDb.GetStateRoot", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.GetStateRoot:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+// GetWithProof 获取已提交的数据和它的证明，proof.Root为最后提交的标志(proof.Flag)的状态根
+func (c *Client) GetWithProof(chain uint64, tbName, key []byte) ([]byte, *Proof, error) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil, nil, err
+		}
+	}
+
+	args := GetArgs{chain, tbName, key}
+	var reply ProofReply
+	err = c.client[id].Call("TDb.GetWithProof", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.GetWithProof:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil, nil, err
+	}
+
+	return reply.Value, &reply.Proof, nil
+}
+
+// VerifyProof 验证数据(空值表示不存在)的证明，不需要连接数据库
+func VerifyProof(root, tbName, key, value []byte, proof *Proof) bool {
+	if proof == nil {
+		return false
+	}
+	p := smt.Proof(*proof)
+	return smt.Verify(root, tbName, key, value, &p)
+}
+
+// GetCommitHash 获取标志提交的hash，它包含前一个标志的hash
+func (c *Client) GetCommitHash(chain uint64, flag []byte) ([]byte, error) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil, err
+		}
+	}
+
+	args := FlagArgs{chain, flag}
+	var reply []byte
+	err = c.client[id].Call("TDb.GetCommitHash", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.GetCommitHash:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+// VerifyChain 用保留的历史数据重新计算标志的hash，返回第一个不一致的标志
+func (c *Client) VerifyChain(chain uint64) (*VerifyChainReply, error) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil, err
+		}
+	}
+
+	var reply VerifyChainReply
+	err = c.client[id].Call("TDb.VerifyChain", &chain, &reply)
+	if err != nil {
+		log.Println("fail to TDb.VerifyChain:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil, err
+	}
+
+	return &reply, nil
+}
+
+// Prune 删除超过保留范围的历史数据，返回被删除历史的标志
+func (c *Client) Prune(chain uint64) ([][]byte, error) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil, err
+		}
+	}
+
+	var reply [][]byte
+	err = c.client[id].Call("TDb.Prune", &chain, &reply)
+	if err != nil {
+		log.Println("fail to TDb.Prune:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+// CreateTable 创建表，确定表的模式。已经确定为其他模式时返回错误
+func (c *Client) CreateTable(chain uint64, tbName []byte, mode TableMode) error {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return err
+		}
+	}
+
+	args := CreateTableArgs{chain, tbName, mode}
+	var reply bool
+	err = c.client[id].Call("TDb.CreateTable", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.CreateTable:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return err
+	}
+
+	return nil
+}
+
+// ListTables 获取有数据的表，按名字排序
+func (c *Client) ListTables(chain uint64) [][]byte {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil
+		}
+	}
+
+	var reply [][]byte
+	err = c.client[id].Call("TDb.ListTables", &chain, &reply)
+	if err != nil {
+		log.Println("fail to TDb.ListTables:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil
+	}
+
+	return reply
+}
+
+// TableStats 获取表的统计信息
+func (c *Client) TableStats(chain uint64, tbName []byte) (*TableStat, error) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil, err
+		}
+	}
+
+	args := GetArgs{chain, tbName, nil}
+	var reply TableStat
+	err = c.client[id].Call("TDb.TableStats", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.TableStats:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil, err
+	}
+
+	return &reply, nil
+}
+
+// DropTable 删除表，不可回滚。有携带标志写入数据的表需要使用DropTableWithFlag
+func (c *Client) DropTable(chain uint64, tbName []byte) error {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return err
+		}
+	}
+
+	args := GetArgs{chain, tbName, nil}
+	var reply bool
+	err = c.client[id].Call("TDb.DropTable", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.DropTable:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return err
+	}
+
+	return nil
+}
+
+// DropTableWithFlag 携带标志删除表中所有数据，可以回滚
+func (c *Client) DropTableWithFlag(chain uint64, flag, tbName []byte) error {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return err
+		}
+	}
+
+	args := DeleteWithFlagArgs{chain, flag, tbName, nil}
+	var reply bool
+	err = c.client[id].Call("TDb.DropTableWithFlag", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.DropTableWithFlag:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return err
+	}
+
+	return nil
+}
+
+// SetBatch 批量存储数据，不携带标签，不会被回滚。在一个事务中写入，失败时不写入任何数据
+func (c *Client) SetBatch(chain uint64, items []BatchItem) error {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return err
+		}
+	}
+
+	args := SetBatchArgs{chain, nil, items}
+	var reply bool
+	err = c.client[id].Call("TDb.SetBatch", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.SetBatch:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return err
+	}
+
+	return nil
+}
+
+// SetWithFlagBatch 批量存储数据，携带标签，可以回滚
+func (c *Client) SetWithFlagBatch(chain uint64, flag []byte, items []BatchItem) error {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return err
+		}
+	}
+
+	args := SetBatchArgs{chain, flag, items}
+	var reply bool
+	err = c.client[id].Call("TDb.SetWithFlagBatch", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.SetWithFlagBatch:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return err
+	}
+
+	return nil
+}
+
+// GetNextKey get next key
+func (c *Client) GetNextKey(chain uint64, tbName, preKey []byte) []byte {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil
+		}
+	}
+
+	args := GetArgs{chain, tbName, preKey}
+	var reply = make([]byte, 500)
+	err = c.client[id].Call("TDb.GetNextKey", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.GetNextKey:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil
+	}
+
+	return reply
+}
+
+// GetPrevKey get previous key, key=nil: get the last key
+func (c *Client) GetPrevKey(chain uint64, tbName, key []byte) []byte {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil
+		}
+	}
+
+	args := GetArgs{chain, tbName, key}
+	var reply []byte
+	err = c.client[id].Call("TDb.GetPrevKey", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.GetPrevKey:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil
+	}
+
+	return reply
+}
+
+// GetLastKey get the last key of the table
+func (c *Client) GetLastKey(chain uint64, tbName []byte) []byte {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil
+		}
+	}
+
+	args := GetArgs{chain, tbName, nil}
+	var reply []byte
+	err = c.client[id].Call("TDb.GetLastKey", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.GetLastKey:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil
+	}
+
+	return reply
+}
+
+// Scan 按key的顺序获取[start,end)范围内的数据，最多limit条(limit<=0:不限制)
+// next为下一个未返回的key(没有更多数据时为nil)，可作为start继续获取
+func (c *Client) Scan(chain uint64, tbName, start, end []byte, limit int) ([]KV, []byte) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil, nil
+		}
+	}
+
+	args := ScanArgs{chain, tbName, start, end, limit, false, nil}
+	var reply ScanReply
+	err = c.client[id].Call("TDb.Scan", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.Scan:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil, nil
+	}
+
+	return reply.Items, reply.Next
+}
+
+// ScanReverse 按key的倒序获取[start,end)范围内的数据，最多limit条(limit<=0:不限制)
+// next为最后一个返回的key(没有更多数据时为nil)，可作为end继续获取
+func (c *Client) ScanReverse(chain uint64, tbName, start, end []byte, limit int) ([]KV, []byte) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil, nil
+		}
+	}
+
+	args := ScanArgs{chain, tbName, start, end, limit, true, nil}
+	var reply ScanReply
+	err = c.client[id].Call("TDb.Scan", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.Scan:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil, nil
+	}
+
+	return reply.Items, reply.Next
+}
+
+// ScanPrefix 获取key以prefix开头的数据，最多limit条
+// next可作为Scan的start继续获取，end为所有以prefix开头的key之后的第一个key
+func (c *Client) ScanPrefix(chain uint64, tbName, prefix []byte, limit int) ([]KV, []byte) {
+	var err error
+	id, ok := <-c.lock
+	if !ok {
+		panic("client closed")
+	}
+	defer func() { c.lock <- id }()
+	if c.client[id] == nil {
+		c.client[id], err = rpc.DialHTTP(c.addrType, c.dbServer)
+		if err != nil {
+			log.Println("fail to DialHTTP.", c.addrType, c.dbServer, err)
+			return nil, nil
+		}
+	}
+
+	args := ScanPrefixArgs{chain, tbName, prefix, limit}
+	var reply ScanReply
+	err = c.client[id].Call("TDb.ScanPrefix", &args, &reply)
+	if err != nil {
+		log.Println("fail to TDb.ScanPrefix:", c.addrType, c.dbServer, err)
+		c.client[id].Close()
+		c.client[id] = nil
+		return nil, nil
+	}
+
+	return reply.Items, reply.Next
 }
 
 // Exist 数据是否存在
@@ -9,6 +9,7 @@ import (
 	"net/rpc"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/lengzhao/database/disk"
 	"github.com/lengzhao/database/server"
@@ -17,6 +18,7 @@ import (
 var (
 	serverAddr string
 	tbName     = []byte("table1")
+	flagTable  = []byte("table2")
 	flag1      = []byte("flag1")
 	flag2      = []byte("flag2")
 	flag3      = []byte("flag3")
@@ -35,7 +37,7 @@ func TestMain(m *testing.M) {
 	defer os.RemoveAll("db_dir")
 	db := server.NewRPCObj("db_dir")
 	server.RegisterAPI(db, func(dir string, id uint64) server.DBApi {
-		m, err := disk.Open(dir)
+		m, err := disk.Open(dir, nil)
 		if err != nil {
 			log.Println("fail to open db manager,dir:", dir, err)
 			return nil
@@ -89,26 +91,26 @@ func TestFlag(t *testing.T) {
 	if err != nil {
 		t.Fatal("fail to set.", err)
 	}
-	oldValue := c.Get(1, tbName, key1)
-	err = c.SetWithFlag(1, flag1, tbName, key1, value1)
+	oldValue := c.Get(1, flagTable, key1)
+	err = c.SetWithFlag(1, flag1, flagTable, key1, value1)
 	if err != nil {
 		t.Fatal("fail to set.", err)
 	}
-	v := c.Get(1, tbName, key1)
+	v := c.Get(1, flagTable, key1)
 	if bytes.Compare(v, value1) != 0 {
 		t.Fatal("different value:", value1, v)
 	}
 	// fmt.Printf("success to get value:%s\n", v)
-	err = c.SetWithFlag(1, flag1, tbName, key1, value2)
+	err = c.SetWithFlag(1, flag1, flagTable, key1, value2)
 	if err != nil {
 		t.Fatal("fail to set.", err)
 	}
-	v2 := c.Get(1, tbName, key1)
+	v2 := c.Get(1, flagTable, key1)
 	if bytes.Compare(v2, value2) != 0 {
 		t.Fatal("different value:", value2, v2)
 	}
 	c.Cancel(1, flag1)
-	v3 := c.Get(1, tbName, key1)
+	v3 := c.Get(1, flagTable, key1)
 	if bytes.Compare(v3, oldValue) != 0 {
 		t.Fatal("different value:", oldValue, v2)
 	}
@@ -180,3 +182,542 @@ func TestGet(t *testing.T) {
 	}
 	c.Commit(1, tbName)
 }
+
+func TestRollbackTo(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 2
+	flags := [][]byte{flag1, flag2, flag3}
+	values := [][]byte{value1, value2, value3}
+	for i, f := range flags {
+		err := c.OpenFlag(chain, f)
+		if err != nil {
+			t.Fatal("fail to open flag.", err)
+		}
+		err = c.SetWithFlag(chain, f, tbName, key1, values[i])
+		if err != nil {
+			t.Fatal("fail to set.", err)
+		}
+		err = c.Commit(chain, f)
+		if err != nil {
+			t.Fatal("fail to commit.", err)
+		}
+	}
+	err := c.RollbackTo(chain, flag1)
+	if err != nil {
+		t.Fatal("fail to rollback.", err)
+	}
+	v := c.Get(chain, tbName, key1)
+	if bytes.Compare(v, value1) != 0 {
+		t.Fatal("different value:", value1, v)
+	}
+	lf := c.GetLastFlag(chain)
+	if bytes.Compare(lf, flag1) != 0 {
+		t.Fatal("different last flag:", flag1, lf)
+	}
+}
+
+func TestScan(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 3
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key:%02d", i)
+		value := fmt.Sprintf("value:%02d", i)
+		err := c.Set(chain, tbName, []byte(key), []byte(value))
+		if err != nil {
+			t.Fatal("fail to set.", err)
+		}
+	}
+	items, next := c.Scan(chain, tbName, []byte("key:05"), nil, 10)
+	if len(items) != 10 || string(next) != "key:15" {
+		t.Fatal("error result:", len(items), string(next))
+	}
+	if string(items[0].Key) != "key:05" || string(items[0].Value) != "value:05" {
+		t.Fatal("error item:", string(items[0].Key), string(items[0].Value))
+	}
+	items, next = c.ScanPrefix(chain, tbName, []byte("key:1"), 0)
+	if len(items) != 10 || next != nil {
+		t.Fatal("error result:", len(items), next)
+	}
+}
+
+func TestScanReverse(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 4
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key:%02d", i)
+		err := c.Set(chain, tbName, []byte(key), []byte(key))
+		if err != nil {
+			t.Fatal("fail to set.", err)
+		}
+	}
+	k := c.GetLastKey(chain, tbName)
+	if string(k) != "key:19" {
+		t.Fatal("error last key:", string(k))
+	}
+	k = c.GetPrevKey(chain, tbName, k)
+	if string(k) != "key:18" {
+		t.Fatal("error previous key:", string(k))
+	}
+	items, next := c.ScanReverse(chain, tbName, nil, nil, 5)
+	if len(items) != 5 || string(next) != "key:15" {
+		t.Fatal("error result:", len(items), string(next))
+	}
+	if string(items[0].Key) != "key:19" {
+		t.Fatal("error item:", string(items[0].Key))
+	}
+}
+
+func TestDelete(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 5
+	c.Set(chain, tbName, key1, value1)
+	err := c.Delete(chain, tbName, key1)
+	if err != nil {
+		t.Fatal("fail to delete.", err)
+	}
+	if c.Exist(chain, tbName, key1) {
+		t.Fatal("hope not exist")
+	}
+
+	c.OpenFlag(chain, flag1)
+	c.SetWithFlag(chain, flag1, flagTable, key2, value2)
+	c.Commit(chain, flag1)
+	c.OpenFlag(chain, flag2)
+	err = c.DeleteWithFlag(chain, flag2, flagTable, key2)
+	if err != nil {
+		t.Fatal("fail to delete.", err)
+	}
+	c.Commit(chain, flag2)
+	if c.Exist(chain, flagTable, key2) {
+		t.Fatal("hope not exist")
+	}
+	c.Rollback(chain, flag2)
+	v := c.Get(chain, flagTable, key2)
+	if bytes.Compare(v, value2) != 0 {
+		t.Fatal("different value:", value2, v)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 6
+	c.OpenFlag(chain, flag1)
+	c.SetWithFlag(chain, flag1, tbName, key1, value1)
+	c.Commit(chain, flag1)
+	st, err := c.Status(chain)
+	if err != nil {
+		t.Fatal("fail to get status.", err)
+	}
+	if bytes.Compare(st.LastFlag, flag1) != 0 {
+		t.Fatal("different last flag:", flag1, st.LastFlag)
+	}
+	if st.Recovery.Journal != "" || len(st.Recovery.RolledBack) > 0 {
+		t.Fatal("error recovery report:", st.Recovery)
+	}
+}
+
+func TestSavepoint(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 7
+	c.OpenFlag(chain, flag1)
+	c.SetWithFlag(chain, flag1, tbName, key1, value1)
+	sp, err := c.Savepoint(chain, flag1)
+	if err != nil {
+		t.Fatal("fail to create savepoint.", err)
+	}
+	c.SetWithFlag(chain, flag1, tbName, key1, value2)
+	err = c.RollbackToSavepoint(chain, flag1, sp)
+	if err != nil {
+		t.Fatal("fail to rollback to savepoint.", err)
+	}
+	v := c.Get(chain, tbName, key1)
+	if bytes.Compare(v, value1) != 0 {
+		t.Fatal("different value:", value1, v)
+	}
+	err = c.ReleaseSavepoint(chain, flag1, sp)
+	if err != nil {
+		t.Fatal("fail to release savepoint.", err)
+	}
+	err = c.ReleaseSavepoint(chain, flag1, sp)
+	if err == nil {
+		t.Fatal("hope error")
+	}
+	c.Commit(chain, flag1)
+}
+
+func TestSiblingFlags(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 8
+	err := c.OpenFlag(chain, flag1)
+	if err != nil {
+		t.Fatal("fail to open flag.", err)
+	}
+	err = c.OpenFlag(chain, flag2)
+	if err != nil {
+		t.Fatal("fail to open sibling flag.", err)
+	}
+	c.SetWithFlag(chain, flag1, tbName, key1, value1)
+	c.SetWithFlag(chain, flag2, tbName, key1, value2)
+	v, err := c.GetWithFlag(chain, flag1, tbName, key1)
+	if err != nil || bytes.Compare(v, value1) != 0 {
+		t.Fatal("different value:", value1, v, err)
+	}
+	items, _, err := c.ScanWithFlag(chain, flag2, tbName, nil, nil, 0, false)
+	if err != nil || len(items) != 1 || bytes.Compare(items[0].Value, value2) != 0 {
+		t.Fatal("error scan:", items, err)
+	}
+	err = c.Commit(chain, flag1)
+	if err != nil {
+		t.Fatal("fail to commit.", err)
+	}
+	if flags := c.GetOpenedFlags(chain); len(flags) != 0 {
+		t.Fatal("hope the sibling flag is canceled:", flags)
+	}
+	if ok, err := c.ExistWithFlag(chain, flag2, tbName, key1); ok || err == nil {
+		t.Fatal("hope error of canceled flag")
+	}
+}
+
+func TestGetAt(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 9
+	flags := [][]byte{flag1, flag2, flag3}
+	values := [][]byte{value1, value2, value3}
+	for i, f := range flags {
+		c.OpenFlag(chain, f)
+		c.SetWithFlag(chain, f, tbName, key1, values[i])
+		err := c.Commit(chain, f)
+		if err != nil {
+			t.Fatal("fail to commit.", err)
+		}
+	}
+	for i, f := range flags {
+		v, err := c.GetAt(chain, f, tbName, key1)
+		if err != nil || bytes.Compare(v, values[i]) != 0 {
+			t.Fatal("different value:", values[i], v, err)
+		}
+	}
+	_, err := c.GetAt(chain, []byte("not exist"), tbName, key1)
+	if err == nil {
+		t.Fatal("hope error")
+	}
+}
+
+func TestGetFlagChanges(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 10
+	c.OpenFlag(chain, flag1)
+	c.SetWithFlag(chain, flag1, tbName, key1, value1)
+	c.SetWithFlag(chain, flag1, tbName, key2, value2)
+	c.Commit(chain, flag1)
+	items, next, err := c.GetFlagChanges(chain, flag1, 0, 1)
+	if err != nil || len(items) != 1 || next != 1 {
+		t.Fatal("error changes:", items, next, err)
+	}
+	if bytes.Compare(items[0].Key, key1) != 0 || bytes.Compare(items[0].Value, value1) != 0 ||
+		len(items[0].PreValue) != 0 {
+		t.Fatal("error change:", items[0])
+	}
+	items, next, err = c.GetFlagChanges(chain, flag1, next, 1)
+	if err != nil || len(items) != 1 || next != 0 || bytes.Compare(items[0].Key, key2) != 0 {
+		t.Fatal("error changes:", items, next, err)
+	}
+}
+
+func TestChangesBetween(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 11
+	c.OpenFlag(chain, flag1)
+	c.SetWithFlag(chain, flag1, tbName, key1, value1)
+	c.Commit(chain, flag1)
+	c.OpenFlag(chain, flag2)
+	c.SetWithFlag(chain, flag2, tbName, key1, value2)
+	c.SetWithFlag(chain, flag2, tbName, key2, value2)
+	c.SetWithFlag(chain, flag2, tbName, key3, value2)
+	c.Commit(chain, flag2)
+	c.OpenFlag(chain, flag3)
+	c.SetWithFlag(chain, flag3, tbName, key1, value1)
+	c.SetWithFlag(chain, flag3, tbName, key2, value3)
+	c.Commit(chain, flag3)
+	items, next, err := c.ChangesBetween(chain, flag1, flag3, nil, 1)
+	if err != nil || len(items) != 1 || next == nil || bytes.Compare(next.Key, key3) != 0 {
+		t.Fatal("error changes:", items, next, err)
+	}
+	if bytes.Compare(items[0].Key, key2) != 0 || bytes.Compare(items[0].Value, value3) != 0 {
+		t.Fatal("error change:", items[0])
+	}
+	items, next, err = c.ChangesBetween(chain, flag1, flag3, next, 1)
+	if err != nil || len(items) != 1 || next != nil || bytes.Compare(items[0].Key, key3) != 0 {
+		t.Fatal("error changes:", items, next, err)
+	}
+}
+
+func TestGetWithProof(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 12
+	c.OpenFlag(chain, flag1)
+	c.SetWithFlag(chain, flag1, tbName, key1, value1)
+	c.SetWithFlag(chain, flag1, tbName, key2, value2)
+	c.Commit(chain, flag1)
+	root, err := c.GetStateRoot(chain, flag1)
+	if err != nil {
+		t.Fatal("fail to get state root.", err)
+	}
+	v, proof, err := c.GetWithProof(chain, tbName, key1)
+	if err != nil || bytes.Compare(v, value1) != 0 {
+		t.Fatal("error value:", v, err)
+	}
+	if !VerifyProof(root, tbName, key1, v, proof) {
+		t.Fatal("fail to verify proof")
+	}
+	if VerifyProof(root, tbName, key1, value2, proof) {
+		t.Fatal("hope fail to verify the error value")
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 13
+	for _, f := range [][]byte{flag1, flag2} {
+		c.OpenFlag(chain, f)
+		c.SetWithFlag(chain, f, tbName, key1, f)
+		c.Commit(chain, f)
+	}
+	h, err := c.GetCommitHash(chain, flag2)
+	if err != nil || len(h) == 0 {
+		t.Fatal("fail to get commit hash:", err)
+	}
+	r, err := c.VerifyChain(chain)
+	if err != nil || r.Checked != 2 || r.Mismatch != nil {
+		t.Fatal("error result of verify:", r, err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 14
+	c.OpenFlag(chain, flag1)
+	c.SetWithFlag(chain, flag1, tbName, key1, value1)
+	c.Commit(chain, flag1)
+	flags, err := c.Prune(chain)
+	if err != nil || len(flags) != 0 {
+		t.Fatal("hope nothing pruned:", flags, err)
+	}
+}
+
+func TestTables(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 15
+	tbName2 := []byte("tbName2")
+	c.Set(chain, tbName2, key1, value1)
+	c.OpenFlag(chain, flag1)
+	c.SetWithFlag(chain, flag1, tbName, key1, value1)
+	c.Commit(chain, flag1)
+	tables := c.ListTables(chain)
+	if len(tables) != 2 {
+		t.Fatalf("error tables:%s", tables)
+	}
+	st, err := c.TableStats(chain, tbName)
+	if err != nil || st.Keys != 1 || !st.Flagged {
+		t.Fatal("error stats:", st, err)
+	}
+	if err = c.DropTable(chain, tbName); err == nil {
+		t.Error("hope error when drop the table with flag data")
+	}
+	if err = c.DropTable(chain, tbName2); err != nil {
+		t.Error("fail to drop table:", err)
+	}
+	c.OpenFlag(chain, flag2)
+	if err = c.DropTableWithFlag(chain, flag2, tbName); err != nil {
+		t.Fatal("fail to drop table with flag:", err)
+	}
+	c.Commit(chain, flag2)
+	if tables = c.ListTables(chain); len(tables) != 0 {
+		t.Fatalf("hope no table:%s", tables)
+	}
+	c.Rollback(chain, flag2)
+	if v := c.Get(chain, tbName, key1); bytes.Compare(v, value1) != 0 {
+		t.Errorf("different value after rollback,hope:%s,get:%s", value1, v)
+	}
+}
+
+func TestTableMode(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 16
+	err := c.CreateTable(chain, tbName, TableModeFlagged)
+	if err != nil {
+		t.Fatal("fail to create table:", err)
+	}
+	if err = c.Set(chain, tbName, key1, value1); !IsTableModeError(err) {
+		t.Error("hope table mode error when write the flagged table without flag:", err)
+	}
+	if !IsTableModeError(&disk.TableModeError{TbName: tbName}) || IsTableModeError(fmt.Errorf("other")) {
+		t.Error("error check of table mode error")
+	}
+	if err = c.CreateTable(chain, tbName, TableModeUnflagged); err == nil {
+		t.Error("hope error when create the table with another mode")
+	}
+	st, err := c.TableStats(chain, tbName)
+	if err != nil || st.Mode != TableModeFlagged {
+		t.Error("error mode:", st, err)
+	}
+}
+
+func TestSetBatch(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 17
+	var items []BatchItem
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("key:%d", i))
+		items = append(items, BatchItem{tbName, k, k})
+	}
+	err := c.SetBatch(chain, items)
+	if err != nil {
+		t.Fatal("fail to set batch:", err)
+	}
+	if st, _ := c.TableStats(chain, tbName); st.Keys != 100 {
+		t.Error("error number of keys:", st)
+	}
+	c.OpenFlag(chain, flag1)
+	err = c.SetWithFlagBatch(chain, flag1, []BatchItem{{flagTable, key1, value1}, {flagTable, key2, value2}})
+	if err != nil {
+		t.Fatal("fail to set batch with flag:", err)
+	}
+	c.Commit(chain, flag1)
+	if v := c.Get(chain, flagTable, key2); bytes.Compare(v, value2) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value2, v)
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 18
+	c.Set(chain, tbName, key1, value1)
+	c.OpenFlag(chain, flag1)
+	c.SetWithFlag(chain, flag1, flagTable, key2, value2)
+	values, found, err := c.GetMulti(chain, []TableKey{{tbName, key1}, {flagTable, key2}, {tbName, key3}})
+	if err != nil || len(values) != 3 || len(found) != 3 {
+		t.Fatal("fail to get multi:", err)
+	}
+	if bytes.Compare(values[0], value1) != 0 || bytes.Compare(values[1], value2) != 0 || values[2] != nil {
+		t.Errorf("error values:%s", values)
+	}
+	if !found[0] || !found[1] || found[2] {
+		t.Error("error found:", found)
+	}
+	c.Cancel(chain, flag1)
+}
+
+func TestCompareAndSet(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 19
+	ok, err := c.SetIfAbsent(chain, tbName, key1, value1)
+	if err != nil || !ok {
+		t.Fatal("fail to set absent key:", ok, err)
+	}
+	if ok, _ = c.SetIfAbsent(chain, tbName, key1, value2); ok {
+		t.Error("hope not set the exist key")
+	}
+	if ok, _ = c.CompareAndSet(chain, tbName, key1, value2, value3); ok {
+		t.Error("hope not set with different expected value")
+	}
+	ok, err = c.CompareAndSet(chain, tbName, key1, value1, value2)
+	if err != nil || !ok {
+		t.Fatal("fail to compare and set:", ok, err)
+	}
+	v := c.Get(chain, tbName, key1)
+	if bytes.Compare(v, value2) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value2, v)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 20
+	c.Increment(chain, tbName, key1, 3)
+	n, err := c.Increment(chain, tbName, key1, 2)
+	if err != nil || n != 5 {
+		t.Fatal("fail to increment:", n, err)
+	}
+	c.Merge(chain, tbName, key2, MergeAppend, value1)
+	v, err := c.Merge(chain, tbName, key2, MergeAppend, value2)
+	if err != nil || bytes.Compare(v, append(append([]byte{}, value1...), value2...)) != 0 {
+		t.Errorf("error append:%s,%v", v, err)
+	}
+
+	c.OpenFlag(chain, flag1)
+	n, err = c.IncrementWithFlag(chain, flag1, flagTable, key1, 7)
+	if err != nil || n != 7 {
+		t.Fatal("fail to increment with flag:", n, err)
+	}
+	v, err = c.MergeWithFlag(chain, flag1, flagTable, key2, MergeMax, value2)
+	if err != nil || bytes.Compare(v, value2) != 0 {
+		t.Errorf("error max:%s,%v", v, err)
+	}
+	c.Commit(chain, flag1)
+	c.Rollback(chain, flag1)
+	if c.Exist(chain, flagTable, key1) {
+		t.Error("hope the counter is rolled back")
+	}
+}
+
+func TestSetWithTTL(t *testing.T) {
+	log.Println("start test:", t.Name())
+	c := New("tcp", serverAddr, 2)
+	defer c.Close()
+	var chain uint64 = 21
+	err := c.SetWithTTL(chain, tbName, key1, value1, time.Hour)
+	if err != nil {
+		t.Fatal("fail to set with ttl:", err)
+	}
+	if !c.Exist(chain, tbName, key1) {
+		t.Error("hope exist before expired")
+	}
+	if c.SetWithTTL(chain, tbName, key2, value2, 0) == nil {
+		t.Error("hope error with invalid ttl")
+	}
+	c.OpenFlag(chain, flag1)
+	c.SetWithFlag(chain, flag1, flagTable, key1, value1)
+	if c.SetWithTTL(chain, flagTable, key1, value1, time.Hour) == nil {
+		t.Error("hope error on flagged table")
+	}
+	c.Cancel(chain, flag1)
+}
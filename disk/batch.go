@@ -0,0 +1,103 @@
+package disk
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// BatchItem the item of batch writing, empty value: delete the key
+type BatchItem struct {
+	TbName []byte
+	Key    []byte
+	Value  []byte
+}
+
+// TableKey the key of table
+type TableKey struct {
+	TbName []byte
+	Key    []byte
+}
+
+// SetBatch set the items in one transaction, unable rollback. Nothing is written if fail.
+func (m *Manager) SetBatch(items []BatchItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tables := make([][]byte, len(items))
+	for i, it := range items {
+		tables[i] = it.TbName
+	}
+	return m.updateUnflagged(tables, func(tx Tx) error {
+		for _, it := range items {
+			b, err := tx.CreateBucketIfNotExists(getLocalTableName(ltnValue, it.TbName))
+			if err != nil {
+				log.Printf("fail to create bucket,%s\n", it.TbName)
+				return err
+			}
+			err = clearExpire(tx, it.TbName, it.Key)
+			if err != nil {
+				return err
+			}
+			err = putOrDelete(b, it.Key, it.Value)
+			if err != nil {
+				log.Println("fail to put:", it.Key, err)
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetWithFlagBatch set the items with flag, enable rollback. Nothing is set if fail.
+func (m *Manager) SetWithFlagBatch(flag []byte, items []BatchItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fs := m.getFlag(flag)
+	if fs == nil {
+		log.Printf("SetBatch:not open flag:%x\n", flag)
+		return fmt.Errorf("not open flag")
+	}
+	tables := make([][]byte, len(items))
+	for i, it := range items {
+		tables[i] = it.TbName
+	}
+	err := m.useFlagMode(fs, tables...)
+	if err != nil {
+		return err
+	}
+	for _, it := range items {
+		fs.set(it.TbName, it.Key, it.Value)
+	}
+	return nil
+}
+
+// GetMulti get the values of the keys in one transaction, in the order of keys.
+// found[i] is false if the key not exist(empty value is same as not exist).
+// It reads the data of the last opened flag.
+func (m *Manager) GetMulti(keys []TableKey) ([][]byte, []bool) {
+	values := make([][]byte, len(keys))
+	found := make([]bool, len(keys))
+	cached := make([]bool, len(keys))
+	m.mu.Lock()
+	fs := m.lastFlag()
+	for i, k := range keys {
+		mk := memKey{}
+		mk.TbName = hex.EncodeToString(k.TbName)
+		mk.Key = hex.EncodeToString(k.Key)
+		if v, ok := fs.lookup(mk); ok {
+			values[i] = v.value
+			cached[i] = true
+		}
+	}
+	m.mu.Unlock()
+	m.dataDb.View(func(tx Tx) error {
+		for i, k := range keys {
+			if !cached[i] {
+				values[i] = getLiveValue(tx, k.TbName, k.Key)
+			}
+			found[i] = len(values[i]) > 0
+		}
+		return nil
+	})
+	return values, found
+}
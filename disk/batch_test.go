@@ -0,0 +1,117 @@
+package disk
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestSetBatch(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	tbName2 := []byte("tbName2")
+	m.Set(tbName2, key3, value3)
+	var items []BatchItem
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("key%03d", i))
+		items = append(items, BatchItem{tbName2, k, k})
+	}
+	items = append(items, BatchItem{tbName2, key3, nil})
+	err = m.SetBatch(items)
+	if err != nil {
+		t.Fatal("fail to set batch:", err)
+	}
+	if st := m.TableStats(tbName2); st.Keys != 100 || m.Exist(tbName2, key3) {
+		t.Error("error data after set batch:", st)
+	}
+
+	m.CreateTable(tbName, TableModeFlagged)
+	tbName3 := []byte("tbName3")
+	err = m.SetBatch([]BatchItem{{tbName2, key3, value3}, {tbName3, key, value}, {tbName, key, value}})
+	if _, ok := err.(*TableModeError); !ok {
+		t.Error("hope table mode error:", err)
+	}
+	if m.Exist(tbName2, key3) {
+		t.Error("hope nothing written when fail")
+	}
+	if mode := m.GetTableMode(tbName3); mode != TableModeNone {
+		t.Error("hope the mode is not declared when fail:", mode)
+	}
+
+	m.OpenFlag(flag)
+	err = m.SetWithFlagBatch(flag, []BatchItem{{tbName, key, value}, {tbName, key2, value2}, {tbName2, key3, value3}})
+	if _, ok := err.(*TableModeError); !ok {
+		t.Error("hope table mode error:", err)
+	}
+	if m.Exist(tbName, key) {
+		t.Error("hope nothing set when fail")
+	}
+	err = m.SetWithFlagBatch(flag, []BatchItem{{tbName3, key, value}, {tbName2, key3, value3}})
+	if _, ok := err.(*TableModeError); !ok {
+		t.Error("hope table mode error:", err)
+	}
+	if mode := m.GetTableMode(tbName3); mode != TableModeNone {
+		t.Error("hope the mode is not declared when fail:", mode)
+	}
+	err = m.SetWithFlagBatch(flag, []BatchItem{{tbName, key, value}, {tbName, key2, value2}})
+	if err != nil {
+		t.Fatal("fail to set batch with flag:", err)
+	}
+	m.Commit(flag)
+	v := m.Get(tbName, key2)
+	if bytes.Compare(v, value2) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value2, v)
+	}
+	m.Rollback(flag)
+	if m.Exist(tbName, key) || m.Exist(tbName, key2) {
+		t.Error("hope the batch is rolled back")
+	}
+	if m.SetWithFlagBatch(flag, nil) == nil {
+		t.Error("hope error of the flag not opened")
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	tbName2 := []byte("tbName2")
+	m.Set(tbName2, key, value)
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key, value)
+	m.SetWithFlag(flag, tbName, key2, value2)
+	m.Commit(flag)
+	m.OpenFlag(flag2)
+	m.SetWithFlag(flag2, tbName, key2, nil)
+	m.SetWithFlag(flag2, tbName, key3, value3)
+
+	keys := []TableKey{{tbName, key}, {tbName, key2}, {tbName, key3}, {tbName2, key}, {tbName2, key2}}
+	hope := [][]byte{value, nil, value3, value, nil}
+	values, found := m.GetMulti(keys)
+	if len(values) != len(keys) || len(found) != len(keys) {
+		t.Fatal("error number of values:", len(values), len(found))
+	}
+	for i := range keys {
+		if bytes.Compare(values[i], hope[i]) != 0 || found[i] != (hope[i] != nil) {
+			t.Errorf("different value,key:%s,hope:%s,get:%s,%t", keys[i].Key, hope[i], values[i], found[i])
+		}
+	}
+	m.Cancel(flag2)
+	values, found = m.GetMulti(keys[1:3])
+	if bytes.Compare(values[0], value2) != 0 || !found[0] || found[1] {
+		t.Error("error values after cancel:", values, found)
+	}
+}
@@ -0,0 +1,142 @@
+package disk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// flagHash the bucket of flag.db, flag -> the commit hash of the flag.
+// commit hash: sha256(the hash of the previous flag + flag + changes), the changes are the
+// data written with flag, in the order of table and key, same as the history.
+const flagHash = "flag_hash"
+
+// commitHash compute the commit hash, changes must be sorted by table and key
+func commitHash(preHash, flag []byte, changes []Change) []byte {
+	h := sha256.New()
+	write := func(data []byte) {
+		h.Write(itoa(uint64(len(data))))
+		h.Write(data)
+	}
+	write(preHash)
+	write(flag)
+	for _, c := range changes {
+		write(c.TbName)
+		write(c.Key)
+		write(c.PreValue)
+		write(c.Value)
+	}
+	return h.Sum(nil)
+}
+
+// journalChanges return the changes of the journal, in the order of table and key
+func journalChanges(j *journal) []Change {
+	var out []Change
+	for _, it := range j.Items {
+		if !it.WithFlag {
+			continue
+		}
+		c := Change{TbName: it.TbName, Key: it.Key}
+		if len(it.PreValue) > 0 {
+			c.PreValue = it.PreValue
+		}
+		if len(it.Value) > 0 {
+			c.Value = it.Value
+		}
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, k int) bool {
+		if r := bytes.Compare(out[i].TbName, out[k].TbName); r != 0 {
+			return r < 0
+		}
+		return bytes.Compare(out[i].Key, out[k].Key) < 0
+	})
+	return out
+}
+
+// getPreHash return the commit hash of the flag before seq, nil if not exist
+func getPreHash(tx Tx, seq uint64) []byte {
+	if seq <= 1 {
+		return nil
+	}
+	pre := tx.Bucket([]byte(flagList)).Get(itoa(seq - 1))
+	if len(pre) == 0 {
+		return nil
+	}
+	v := tx.Bucket([]byte(flagHash)).Get(pre)
+	if len(v) == 0 {
+		return nil
+	}
+	return dup(v)
+}
+
+// GetCommitHash return the commit hash of the flag
+func (m *Manager) GetCommitHash(flag []byte) ([]byte, error) {
+	var out []byte
+	m.flagDb.View(func(tx Tx) error {
+		if v := tx.Bucket([]byte(flagHash)).Get(flag); len(v) > 0 {
+			out = dup(v)
+		}
+		return nil
+	})
+	if out == nil {
+		return nil, fmt.Errorf("not found commit hash")
+	}
+	return out, nil
+}
+
+// VerifyChain recompute the commit hashes of the flags whose history is retained(see Options),
+// return the number of checked flags and the first flag whose hash is different(nil: all right).
+// The flags committed before the commit hash was supported are skipped.
+// The flags are got with the lock, the history is read without it, so the writers are not blocked.
+func (m *Manager) VerifyChain() (int, []byte, error) {
+	type item struct {
+		flag    []byte
+		hash    []byte
+		preHash []byte
+	}
+	var items []item
+	m.mu.Lock()
+	m.flagDb.View(func(tx Tx) error {
+		c := tx.Bucket([]byte(flagList)).Cursor()
+		b := tx.Bucket([]byte(flagHash))
+		var preHash []byte
+		for k, v := c.Seek(itoa(1)); k != nil; k, v = c.Next() {
+			hash := b.Get(v)
+			if len(hash) > 0 {
+				items = append(items, item{dup(v), dup(hash), preHash})
+				preHash = dup(hash)
+			} else {
+				preHash = nil
+			}
+		}
+		return nil
+	})
+	var checked []item
+	for _, it := range items {
+		if m.checkHistory(it.flag) == nil {
+			checked = append(checked, it)
+		}
+	}
+	m.mu.Unlock()
+
+	var n int
+	for _, it := range checked {
+		changes, _, err := m.flagChanges(it.flag, 0, 0)
+		if err != nil && m.checkHistory(it.flag) != nil {
+			// the history is removed by Prune or Rollback after the lock is released
+			continue
+		}
+		if err != nil {
+			return n, nil, err
+		}
+		n++
+		if bytes.Compare(commitHash(it.preHash, it.flag, changes), it.hash) != 0 {
+			log.Printf("different commit hash,flag:%x\n", it.flag)
+			return n, it.flag, nil
+		}
+	}
+	return n, nil, nil
+}
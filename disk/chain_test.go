@@ -0,0 +1,64 @@
+package disk
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestVerifyChain(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	flags := [][]byte{flag, flag2, flag3}
+	values := [][]byte{value, value2, value3}
+	var hashes [][]byte
+	for i, f := range flags {
+		m.OpenFlag(f)
+		m.SetWithFlag(f, tbName, key, values[i])
+		m.SetWithFlag(f, tbName, key2, values[i])
+		m.Commit(f)
+		h, err := m.GetCommitHash(f)
+		if err != nil {
+			t.Fatal("fail to get commit hash.", err)
+		}
+		hashes = append(hashes, h)
+	}
+	if bytes.Compare(hashes[0], hashes[1]) == 0 {
+		t.Error("hope different hash")
+	}
+	n, mismatch, err := m.VerifyChain()
+	if err != nil || n != 3 || mismatch != nil {
+		t.Fatal("error result of verify:", n, mismatch, err)
+	}
+
+	// the hash is chained to the previous one
+	m.Rollback(flag3)
+	if _, err = m.GetCommitHash(flag3); err == nil {
+		t.Error("hope the hash of rolled back flag is removed")
+	}
+	m.Rollback(flag2)
+	m.OpenFlag(flag2)
+	m.SetWithFlag(flag2, tbName, key, value2)
+	m.SetWithFlag(flag2, tbName, key2, value2)
+	m.Commit(flag2)
+	h, _ := m.GetCommitHash(flag2)
+	if bytes.Compare(h, hashes[1]) != 0 {
+		t.Error("hope the same hash of the same changes")
+	}
+
+	// modify the history of flag
+	m.flagDb.Update(func(tx Tx) error {
+		return tx.Bucket([]byte(flagHash)).Put(flag, hashes[1])
+	})
+	n, mismatch, err = m.VerifyChain()
+	if err != nil || n != 1 || bytes.Compare(mismatch, flag) != 0 {
+		t.Error("hope mismatch of flag:", n, mismatch, err)
+	}
+}
@@ -5,11 +5,11 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
-	"github.com/boltdb/bolt"
 	"log"
 	"os"
 	"path"
 	"sync"
+	"time"
 )
 
 type memKey struct {
@@ -21,19 +21,35 @@ type memValue struct {
 	tbName   []byte
 	key      []byte
 	value    []byte
-	preFlag  []byte
-	preValue []byte
 	withFlag bool
 }
 
+// KV key and value
+type KV struct {
+	Key   []byte
+	Value []byte
+}
+
 // Manager manager
 type Manager struct {
 	mu     sync.Mutex
-	cache  map[memKey]*memValue
-	flagDb *bolt.DB
-	dataDb *bolt.DB
-	flag   []byte
-	dir    string
+	flagDb Engine
+	dataDb Engine
+	// historyDb the history of the committed flags
+	historyDb Engine
+	dir       string
+	opt       Options
+	report    RecoveryReport
+	// the opened flags, in the order of opening
+	flags []*flagState
+	spID  uint64
+	// the mode of the used tables, tbName -> mode
+	modes map[string]TableMode
+	// failed the error of the commit which is not fully applied, the manager must be reopened to recover it
+	failed error
+	// done stop the reaper of the expired keys
+	done chan struct{}
+	wg   sync.WaitGroup
 }
 
 const (
@@ -47,61 +63,108 @@ const (
 	ltnValue = iota
 	ltnFlag
 	ltnPreValue
+	// ltnExpire key -> expire time(unix nano), see SetWithTTL
+	ltnExpire
+	// ltnMode the bucket of history, the mode of the tables before the flag dropped them, see DropTableWithFlag
+	ltnMode
 )
 
-// HistoryMax the number of history files(rollback times)
-var HistoryMax uint64 = 2000
-
-// Open open manager,if not exist,create it
-func Open(dir string) (*Manager, error) {
+// Open open manager,if not exist,create it. opt=nil: the default options.
+// The unfinished commit or rollback is recovered before return, see RecoveryReport.
+func Open(dir string, opt *Options) (*Manager, error) {
 	out := new(Manager)
 	out.mu.Lock()
 	defer out.mu.Unlock()
 	out.dir = dir
-	out.cache = make(map[memKey]*memValue)
+	out.modes = make(map[string]TableMode)
+	if opt != nil {
+		out.opt = *opt
+	}
+	out.opt.init()
 	_, err := os.Stat(dir)
-	if os.IsNotExist(err) {
+	if os.IsNotExist(err) && !out.inMemory() {
 		err = os.Mkdir(dir, fileMode)
 		if err != nil {
 			log.Println("create dir:", dir, err)
 			return nil, err
 		}
 	}
-	out.dataDb, err = bolt.Open(path.Join(dir, dataFN), fileMode, nil)
+	out.dataDb, err = openEngine(out.opt.Engine, path.Join(dir, dataFN))
 	if err != nil {
 		log.Println("fail to open file:", dir, dataFN, err)
 		return nil, err
 	}
-	out.flagDb, err = bolt.Open(path.Join(dir, flagFN), fileMode, nil)
+	out.flagDb, err = openEngine(out.opt.Engine, path.Join(dir, flagFN))
 	if err != nil {
 		out.dataDb.Close()
 		log.Println("fail to open file:", dir, flagFN, err)
 		return nil, err
 	}
+	out.historyDb, err = openEngine(out.opt.Engine, path.Join(dir, historyFN))
+	if err != nil {
+		out.dataDb.Close()
+		out.flagDb.Close()
+		log.Println("fail to open file:", dir, historyFN, err)
+		return nil, err
+	}
 
-	err = out.flagDb.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte(flagList))
+	err = out.flagDb.Update(func(tx Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(flagList))
 		if err != nil {
 			log.Println("fail to create flagList.", err)
 			return err
 		}
-		c := b.Cursor()
-		_, v1 := c.Last()
-		_, v2 := c.First()
-		if bytes.Compare(v1, v2) != 0 {
-			log.Println("different flag,rollback")
-			lastFlag := make([]byte, len(v1))
-			copy(lastFlag, v1)
-			go out.Rollback(lastFlag)
+		_, err = tx.CreateBucketIfNotExists([]byte(flagRoot))
+		if err != nil {
+			log.Println("fail to create flagRoot.", err)
+			return err
 		}
-		return nil
+		_, err = tx.CreateBucketIfNotExists([]byte(flagHash))
+		if err != nil {
+			log.Println("fail to create flagHash.", err)
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(flagTime))
+		if err != nil {
+			log.Println("fail to create flagTime.", err)
+		}
+		return err
 	})
+	if err == nil {
+		err = out.historyDb.Update(func(tx Tx) error {
+			_, err := tx.CreateBucketIfNotExists([]byte(historyIndex))
+			return err
+		})
+	}
+	if err == nil {
+		err = out.dataDb.Update(smtInit)
+	}
+	if err == nil {
+		err = out.dataDb.Update(func(tx Tx) error {
+			_, err := tx.CreateBucketIfNotExists([]byte(tableMode))
+			if err != nil {
+				return err
+			}
+			_, err = tx.CreateBucketIfNotExists([]byte(expireIndex))
+			return err
+		})
+	}
+	if err == nil {
+		err = out.migrateHistory()
+	}
+	if err == nil {
+		err = out.recover()
+	}
 	if err != nil {
 		out.dataDb.Close()
 		out.flagDb.Close()
+		out.historyDb.Close()
 		log.Println("fail to open file:", dir, flagFN, err)
 		return nil, err
 	}
+	out.done = make(chan struct{})
+	out.wg.Add(1)
+	go out.reap(out.done)
 	log.Println("open database manager:", dir)
 	return out, nil
 }
@@ -109,6 +172,11 @@ func Open(dir string) (*Manager, error) {
 // Close close manager
 func (m *Manager) Close() {
 	log.Println("start to close manager:", m.dir)
+	if m.done != nil {
+		close(m.done)
+		m.wg.Wait()
+		m.done = nil
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	defer func() {
@@ -120,42 +188,45 @@ func (m *Manager) Close() {
 			m.dataDb.Close()
 			m.dataDb = nil
 		}
-	}()
-	if len(m.cache) > 0 {
-		tx2, err := m.dataDb.Begin(true)
-		if err != nil {
-			log.Println("fail to begin flag file:", err)
-			return
+		if m.historyDb != nil {
+			m.historyDb.Close()
+			m.historyDb = nil
 		}
-		defer tx2.Rollback()
-		for _, mv := range m.cache {
-			if mv.withFlag {
-				continue
-			}
-			b, err := tx2.CreateBucketIfNotExists(getLocalTableName(ltnValue, mv.tbName))
-			if err != nil {
-				log.Println("fail to create bucket(history value):", mv.tbName, err)
-				return
-			}
-			err = b.Put(mv.key, mv.value)
-			if err != nil {
-				log.Println("fail to put bucket(value):", mv.tbName, mv.key, err)
-				return
-			}
-		}
-		tx2.Commit()
+	}()
+	for _, fs := range m.flags {
+		m.writeWithoutFlag(fs)
 	}
+	m.flags = nil
 	log.Println("manager closed:", m.dir)
 }
 
+// inMemory return true if the data is not written to file, then the journal is not used
+func (m *Manager) inMemory() bool {
+	return m.opt.Engine == EngineMemory
+}
+
+// getHistoryFileName the history file of the old version, see migrateHistory
 func (m *Manager) getHistoryFileName(flag []byte) string {
 	fn := hex.EncodeToString(flag)
 	rfn := path.Join(m.dir, fn)
 	return rfn + ".h"
 }
 
-// OpenFlag open flag
+// OpenFlag open flag on the last committed flag
 func (m *Manager) OpenFlag(flag []byte) error {
+	return m.openFlag(nil, flag)
+}
+
+// OpenFlagOn open flag on another opened flag(parent), it reads the data of parent.
+// The flag can be committed after parent is committed.
+func (m *Manager) OpenFlagOn(parent, flag []byte) error {
+	if len(parent) == 0 {
+		return fmt.Errorf("try to open flag on null flag")
+	}
+	return m.openFlag(parent, flag)
+}
+
+func (m *Manager) openFlag(parent, flag []byte) error {
 	if len(flag) > 100 {
 		return fmt.Errorf("flag too long(<100)")
 	}
@@ -165,18 +236,32 @@ func (m *Manager) OpenFlag(flag []byte) error {
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if len(m.flag) != 0 {
-		log.Printf("exist flag:%x,try to open:%x\n", m.flag, flag)
+	if m.failed != nil {
+		return m.failed
+	}
+	if m.getFlag(flag) != nil {
+		log.Printf("exist flag:%x\n", flag)
 		return fmt.Errorf("exist flag")
 	}
-	rfn := m.getHistoryFileName(flag)
-	if _, err := os.Stat(rfn); !os.IsNotExist(err) {
-		log.Printf("exist flag file:%s,flag:%x\n", rfn, flag)
-		return fmt.Errorf("exist flag file")
+	var p *flagState
+	if len(parent) > 0 {
+		p = m.getFlag(parent)
+		if p == nil {
+			log.Printf("not found parent flag:%x,try to open:%x\n", parent, flag)
+			return fmt.Errorf("not open parent flag")
+		}
+	}
+	if m.checkHistory(flag) == nil {
+		log.Printf("exist the history of flag:%x\n", flag)
+		return fmt.Errorf("exist flag history")
 	}
 
-	m.flag = flag
-	m.cache = make(map[memKey]*memValue)
+	fs := &flagState{flag: flag, parent: p}
+	fs.cache = make(map[memKey]*memValue)
+	fs.tables = make(map[string]bool)
+	fs.dropped = make(map[string]bool)
+	fs.index = make(map[string]*skipList)
+	m.flags = append(m.flags, fs)
 	return nil
 }
 
@@ -187,6 +272,20 @@ func getLocalTableName(typ byte, tbName []byte) []byte {
 	return out
 }
 
+// putOrDelete put the value, or delete the key if the value is empty
+func putOrDelete(b Bucket, key, value []byte) error {
+	if len(value) == 0 {
+		return b.Delete(key)
+	}
+	return b.Put(key, value)
+}
+
+func dup(in []byte) []byte {
+	out := make([]byte, len(in))
+	copy(out, in)
+	return out
+}
+
 func itoa(in uint64) []byte {
 	out := make([]byte, 8)
 	binary.BigEndian.PutUint64(out, in)
@@ -200,19 +299,21 @@ func atoi(in []byte) uint64 {
 	return binary.BigEndian.Uint64(in)
 }
 
-// GetLastFlag get last flag
+// GetLastFlag get last flag, it is the last opened flag if exist.
+// With several opened flags, it is the flag opened last which is still opened(same as the only opened flag before);
+// Get/Exist/Scan read the data of this flag, use GetWithFlag/ScanWithFlag to read the other flags.
 func (m *Manager) GetLastFlag() []byte {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if len(m.flag) != 0 {
-		return m.flag
+	if fs := m.lastFlag(); fs != nil {
+		return fs.flag
 	}
 	var out []byte
-	m.flagDb.View(func(tx *bolt.Tx) error {
+	m.flagDb.View(func(tx Tx) error {
 		b := tx.Bucket([]byte(flagList))
 		c := b.Cursor()
 		_, v := c.Last()
-		if v != nil {
+		if len(v) > 0 {
 			out = make([]byte, len(v))
 			copy(out, v)
 		}
@@ -221,169 +322,129 @@ func (m *Manager) GetLastFlag() []byte {
 	return out
 }
 
-// Commit write data to disk
+// Commit write data to disk, the flag must be opened on the last committed flag.
+// The other flags opened on the last committed flag are canceled,
+// the flags opened on this flag are kept and opened on the new committed flag.
+// If the commit fails after the journal is written, every write returns error until the manager is reopened,
+// then the commit is replayed.
 func (m *Manager) Commit(flag []byte) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if len(m.flag) == 0 {
+	if m.failed != nil {
+		return m.failed
+	}
+	fs := m.getFlag(flag)
+	if fs == nil {
 		return fmt.Errorf("not open flag")
 	}
-	if bytes.Compare(flag, m.flag) != 0 {
-		log.Println("try to commit different flag,")
-		return fmt.Errorf("different flag")
+	if fs.parent != nil {
+		log.Printf("try to commit flag:%x,parent flag is not committed:%x\n", flag, fs.parent.flag)
+		return fmt.Errorf("parent flag not committed")
 	}
-	// set last flag
-	err := m.flagDb.Update(func(tx *bolt.Tx) error {
+	j := &journal{Flag: flag, Time: time.Now().UnixNano()}
+	m.flagDb.View(func(tx Tx) error {
 		b2 := tx.Bucket([]byte(flagList))
-		c := b2.Cursor()
-		last, _ := c.Last()
-		next := atoi(last) + 1
-		if next > HistoryMax {
-			v := b2.Get(itoa(next - HistoryMax))
-			if len(v) > 0 {
-				fn := m.getHistoryFileName(v)
-				os.Remove(fn)
+		last, _ := b2.Cursor().Last()
+		j.Seq = atoi(last) + 1
+		return nil
+	})
+	// the previous data is read when commit, it is changed by the commit of parent
+	m.dataDb.View(func(tx Tx) error {
+		for _, mv := range fs.cache {
+			it := journalItem{TbName: mv.tbName, Key: mv.key, Value: mv.value, WithFlag: mv.withFlag}
+			if mv.withFlag {
+				it.PreFlag = getValue(tx, ltnFlag, mv.tbName, mv.key)
+				it.PreValue = getValue(tx, ltnValue, mv.tbName, mv.key)
 			}
+			j.Items = append(j.Items, it)
 		}
-		k := itoa(next)
-		return b2.Put(k, flag)
+		for tb := range fs.dropped {
+			d := journalDrop{TbName: []byte(tb)}
+			if v := tx.Bucket([]byte(tableMode)).Get(getLocalTableName(ltnValue, d.TbName)); len(v) == 1 {
+				d.PreMode = TableMode(v[0])
+			}
+			j.Dropped = append(j.Dropped, d)
+		}
+		return nil
 	})
+	// nothing is changed if fail to write journal
+	err := m.writeJournal(j)
 	if err != nil {
-		log.Println("fail to set lastFlag.", err)
+		m.removeJournal()
 		return err
 	}
-	// write data to file for rollback
-	rfn := m.getHistoryFileName(flag)
-	history, err := bolt.Open(rfn, fileMode, nil)
+	err = m.applyJournal(j)
 	if err != nil {
-		log.Println("fail to open flag file:", rfn, err)
+		// the journal is kept, it is replayed when Open
+		log.Printf("fail to commit,flag:%x,%s\n", flag, err)
+		m.failed = fmt.Errorf("unfinished commit of flag %x, reopen to recover:%s", flag, err)
 		return err
 	}
-	defer func() {
-		if history != nil {
-			history.Close()
-			os.Remove(rfn)
-		}
-	}()
-	tx1, err := history.Begin(true)
-	if err != nil {
-		log.Println("fail to begin flag file:", err)
-		return err
+	m.removeJournal()
+	m.prune()
+	for _, d := range j.Dropped {
+		delete(m.modes, string(d.TbName))
 	}
-	defer tx1.Rollback()
-	for _, mv := range m.cache {
-		if !mv.withFlag {
-			continue
-		}
-		b1, err := tx1.CreateBucketIfNotExists(getLocalTableName(ltnFlag, mv.tbName))
-		if err != nil {
-			log.Println("fail to create bucket(history flag):", mv.tbName, err)
-			return err
-		}
-		err = b1.Put(mv.key, mv.preFlag)
-		if err != nil {
-			log.Println("fail to put bucket(flag):", mv.tbName, mv.key, err)
-			return err
-		}
-		b2, err := tx1.CreateBucketIfNotExists(getLocalTableName(ltnPreValue, mv.tbName))
-		if err != nil {
-			log.Println("fail to create bucket(history preValue):", mv.tbName, err)
-			return err
-		}
-		err = b2.Put(mv.key, mv.preValue)
-		if err != nil {
-			log.Println("fail to put bucket(preValue):", mv.tbName, mv.key, err)
-			return err
-		}
-		b3, err := tx1.CreateBucketIfNotExists(getLocalTableName(ltnValue, mv.tbName))
-		if err != nil {
-			log.Println("fail to create bucket(history value):", mv.tbName, err)
-			return err
-		}
-		err = b3.Put(mv.key, mv.value)
-		if err != nil {
-			log.Println("fail to put bucket(value):", mv.tbName, mv.key, err)
-			return err
-		}
-	}
-	tx1.Commit()
-	history.Close()
-	history = nil
 
-	// write data to data.db
-	tx2, err := m.dataDb.Begin(true)
-	if err != nil {
-		log.Println("fail to begin flag file:", err)
-		return err
-	}
-	defer tx2.Rollback()
-	for _, mv := range m.cache {
-		if !mv.withFlag {
+	// reset flags
+	var flags []*flagState
+	for _, it := range m.flags {
+		if it == fs {
 			continue
 		}
-		b, err := tx2.CreateBucketIfNotExists(getLocalTableName(ltnFlag, mv.tbName))
-		if err != nil {
-			log.Println("fail to create bucket(history flag):", mv.tbName, err)
-			return err
-		}
-		err = b.Put(mv.key, m.flag)
-		if err != nil {
-			log.Println("fail to put bucket(flag):", mv.tbName, mv.key, err)
-			return err
+		if it.root() != fs {
+			log.Printf("cancel flag:%x,committed flag:%x\n", it.flag, flag)
+			continue
 		}
+		flags = append(flags, it)
 	}
-	for _, mv := range m.cache {
-		b, err := tx2.CreateBucketIfNotExists(getLocalTableName(ltnValue, mv.tbName))
-		if err != nil {
-			log.Println("fail to create bucket(history value):", mv.tbName, err)
-			return err
-		}
-		err = b.Put(mv.key, mv.value)
-		if err != nil {
-			log.Println("fail to put bucket(value):", mv.tbName, mv.key, err)
-			return err
+	for _, it := range flags {
+		if it.parent == fs {
+			it.parent = nil
 		}
 	}
-	tx2.Commit()
-
-	// reset flag
-	m.flag = nil
-	m.cache = make(map[memKey]*memValue)
-	err = m.flagDb.Update(func(tx *bolt.Tx) error {
-		b2 := tx.Bucket([]byte(flagList))
-		b2.Put(itoa(0), flag)
-		return nil
-	})
-	if err != nil {
-		log.Println("fail to update lastFlag.", err)
-		return err
-	}
+	m.flags = flags
 	log.Printf("success to commit,flag:%x\n", flag)
 	return nil
 }
 
-// Cancel cancel flag,not write to disk
+// Cancel cancel flag,not write to disk. the flags opened on it are canceled too
 func (m *Manager) Cancel(flag []byte) error {
-	if len(m.flag) == 0 {
-		return fmt.Errorf("not open flag")
-	}
-
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if bytes.Compare(flag, m.flag) != 0 {
-		log.Println("try to cancel different flag")
-		return fmt.Errorf("different flag")
+	if m.failed != nil {
+		return m.failed
 	}
-	rfn := m.getHistoryFileName(flag)
-	defer os.Remove(rfn)
+	fs := m.getFlag(flag)
+	if fs == nil {
+		log.Printf("try to cancel flag not opened:%x\n", flag)
+		return fmt.Errorf("not open flag")
+	}
+	err := m.writeWithoutFlag(fs)
+	if err != nil {
+		return err
+	}
+	var flags []*flagState
+	for _, it := range m.flags {
+		if it == fs || it.isOn(fs) {
+			continue
+		}
+		flags = append(flags, it)
+	}
+	m.flags = flags
 
+	return nil
+}
+
+// writeWithoutFlag write the data in cache which is not set with flag
+func (m *Manager) writeWithoutFlag(fs *flagState) error {
 	tx2, err := m.dataDb.Begin(true)
 	if err != nil {
 		log.Println("fail to begin flag file:", err)
 		return err
 	}
 	defer tx2.Rollback()
-	for _, mv := range m.cache {
+	for _, mv := range fs.cache {
 		if mv.withFlag {
 			continue
 		}
@@ -392,29 +453,29 @@ func (m *Manager) Cancel(flag []byte) error {
 			log.Println("fail to create bucket(history value):", mv.tbName, err)
 			return err
 		}
-		err = b.Put(mv.key, mv.value)
+		err = putOrDelete(b, mv.key, mv.value)
 		if err != nil {
 			log.Println("fail to put bucket(value):", mv.tbName, mv.key, err)
 			return err
 		}
 	}
-	tx2.Commit()
-	m.flag = nil
-	m.cache = make(map[memKey]*memValue)
-
-	return nil
+	return tx2.Commit()
 }
 
-// Rollback rollback data of flag
+// Rollback rollback data of flag, it fails without any change if the history of flag has been removed
 func (m *Manager) Rollback(flag []byte) error {
 	log.Printf("rollback:%x\n", flag)
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if len(m.flag) > 0 {
-		log.Println("rollback,exist opened flag,", m.flag)
+	if m.failed != nil {
+		return m.failed
+	}
+	if len(m.flags) > 0 {
+		log.Printf("rollback,exist opened flag:%x\n", m.flags[0].flag)
 		return fmt.Errorf("exist opened flag")
 	}
-	err := m.flagDb.View(func(tx *bolt.Tx) error {
+	var preFlag []byte
+	err := m.flagDb.View(func(tx Tx) error {
 		b := tx.Bucket([]byte(flagList))
 		c := b.Cursor()
 		_, v := c.Last()
@@ -422,129 +483,265 @@ func (m *Manager) Rollback(flag []byte) error {
 			log.Printf("different last flag,hope(in db):%x,input:%x\n", v, flag)
 			return fmt.Errorf("not last flag")
 		}
+		k, v := c.Prev()
+		if atoi(k) > 0 {
+			preFlag = dup(v)
+		}
 		return nil
 	})
 	if err != nil {
 		log.Println("different last flag.", err)
 		return err
 	}
+	err = m.checkHistory(flag)
+	if err != nil {
+		return err
+	}
 
-	tx2, err := m.dataDb.Begin(true)
+	return m.rollback([][]byte{flag}, preFlag, false)
+}
+
+// RollbackTo rollback all flags committed after flag, then flag is the last flag.
+// It fails without any change if the history of one of the flags has been removed.
+func (m *Manager) RollbackTo(flag []byte) error {
+	log.Printf("rollback to:%x\n", flag)
+	if len(flag) == 0 {
+		return fmt.Errorf("try to rollback to null flag")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failed != nil {
+		return m.failed
+	}
+	if len(m.flags) > 0 {
+		log.Printf("rollback,exist opened flag:%x\n", m.flags[0].flag)
+		return fmt.Errorf("exist opened flag")
+	}
+	flags, err := m.flagsAfter(flag)
 	if err != nil {
-		log.Println("fail to begin flag file:", err)
 		return err
 	}
-	defer tx2.Rollback()
-	rfn := m.getHistoryFileName(flag)
-	defer os.Remove(rfn)
-	history, err := bolt.Open(rfn, fileMode, nil)
+	err = m.checkHistory(flags...)
 	if err != nil {
-		log.Println("fail to open flag file:", rfn, err)
 		return err
 	}
-	defer history.Close()
-	err = history.View(func(tx *bolt.Tx) error {
-		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
-			typ := name[0]
-			if typ == ltnValue {
+
+	return m.rollback(flags, flag, false)
+}
+
+// flagsAfter return the flags committed after flag(from the last flag), flag=nil: all flags
+func (m *Manager) flagsAfter(flag []byte) ([][]byte, error) {
+	var flags [][]byte
+	err := m.flagDb.View(func(tx Tx) error {
+		b := tx.Bucket([]byte(flagList))
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && atoi(k) > 0; k, v = c.Prev() {
+			if len(flag) > 0 && bytes.Compare(v, flag) == 0 {
 				return nil
 			}
-			tn := name[1:]
-			if typ == ltnFlag {
-				b2 := tx2.Bucket(getLocalTableName(ltnFlag, tn))
-				return b.ForEach(func(key, flag []byte) error {
-					return b2.Put(key, flag)
-				})
-			}
-			b2 := tx2.Bucket(getLocalTableName(ltnValue, tn))
-			return b.ForEach(func(key, flag []byte) error {
-				return b2.Put(key, flag)
-			})
-		})
+			flags = append(flags, dup(v))
+		}
+		if len(flag) == 0 {
+			return nil
+		}
+		log.Printf("not found the flag:%x\n", flag)
+		return fmt.Errorf("not found flag")
 	})
-	tx2.Commit()
+	return flags, err
+}
+
+// rollback restore the data of the flags(from the last flag), then target is the last flag.
+// target is recorded as the committed flag first, all data are restored in one transaction,
+// then the flags are removed from flag list.
+// If the process crashes, Open finds the committed flag is not the last flag and rollback again.
+// If it fails after target is recorded, every write returns error until the manager is reopened.
+// recovering: called by Open, the flag without history(its commit was not finished) is skipped,
+// otherwise the history of all flags must exist.
+func (m *Manager) rollback(flags [][]byte, target []byte, recovering bool) error {
+	if len(flags) == 0 {
+		return nil
+	}
+	err := m.flagDb.Update(func(tx Tx) error {
+		b2 := tx.Bucket([]byte(flagList))
+		return b2.Put(itoa(0), target)
+	})
+	if err != nil {
+		log.Println("fail to update lastFlag.", err)
+		return err
+	}
+	err = m.undoFlags(flags, recovering)
+	if err != nil {
+		m.failed = fmt.Errorf("unfinished rollback to flag %x, reopen to recover:%s", target, err)
+	}
+	return err
+}
+
+// undoFlags restore the data of the flags and remove them from flag list, see rollback
+func (m *Manager) undoFlags(flags [][]byte, recovering bool) error {
+	err := commitHook(stepRollbackFlag)
+	if err != nil {
+		return err
+	}
+	tx2, err := m.dataDb.Begin(true)
+	if err != nil {
+		log.Println("fail to begin flag file:", err)
+		return err
+	}
+	defer tx2.Rollback()
+	for _, flag := range flags {
+		err = m.undoFlag(tx2, flag, recovering)
+		if err != nil {
+			log.Printf("fail to rollback flag:%x,%s\n", flag, err)
+			return err
+		}
+	}
+	err = tx2.Commit()
+	if err != nil {
+		log.Println("fail to commit data.", err)
+		return err
+	}
+	// the mode of the tables only written by the flags is undone, see useFlagMode
+	m.modes = make(map[string]TableMode)
+	err = commitHook(stepRollbackData)
+	if err != nil {
+		return err
+	}
 
-	// update last flag
-	err = m.flagDb.Update(func(tx *bolt.Tx) error {
+	// remove the flags from flag list
+	err = m.flagDb.Update(func(tx Tx) error {
 		b2 := tx.Bucket([]byte(flagList))
 		c := b2.Cursor()
-		c.Last()
-		c.Delete()
-		_, v := c.Last()
-		b2.Put(itoa(0), v)
+		var keys [][]byte
+		for k, _ := c.Last(); k != nil && atoi(k) > 0 && len(keys) < len(flags); k, _ = c.Prev() {
+			keys = append(keys, dup(k))
+		}
+		for _, k := range keys {
+			err := b2.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		b3 := tx.Bucket([]byte(flagRoot))
+		b4 := tx.Bucket([]byte(flagHash))
+		b5 := tx.Bucket([]byte(flagTime))
+		for _, f := range flags {
+			err := b3.Delete(f)
+			if err != nil {
+				return err
+			}
+			err = b4.Delete(f)
+			if err != nil {
+				return err
+			}
+			err = b5.Delete(f)
+			if err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 	if err != nil {
 		log.Println("fail to update lastFlag.", err)
 		return err
 	}
-
-	return nil
+	return m.removeHistory(flags...)
 }
 
-// SetWithFlag set data with flag, enable rollback
-func (m *Manager) SetWithFlag(flag, tbName, key, value []byte) error {
-	if bytes.Compare(m.flag, flag) != 0 {
-		log.Printf("Set:different flag,hope:%x,error:%x\n", m.flag, flag)
-		return fmt.Errorf("different flag")
+// undoFlag write the history data of flag to data.db, empty preValue/preFlag means the key did not exist.
+// the history is closed before tx2 is committed, so the data is copied.
+// recovering: return nil if the history not exist, see rollback
+func (m *Manager) undoFlag(tx2 Tx, flag []byte, recovering bool) error {
+	if recovering && m.checkHistory(flag) != nil {
+		// the commit of the flag was not finished, no data to restore
+		return nil
 	}
-	// log.Printf("SetWithFlag: flag:%x,tbName:%s,key:%x,len:%d\n", flag, tbName, key, len(value))
-	mk := memKey{}
-	mk.TbName = hex.EncodeToString(tbName)
-	mk.Key = hex.EncodeToString(key)
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	mv, ok := m.cache[mk]
-	if !ok {
-		mv = new(memValue)
-		mv.tbName = tbName
-		mv.key = key
-		m.dataDb.View(func(tx *bolt.Tx) error {
-			b := tx.Bucket(getLocalTableName(ltnFlag, tbName))
-			if b == nil {
+	return m.viewHistory(flag, func(hb Bucket) error {
+		return hb.ForEach(func(name, _ []byte) error {
+			b := hb.Bucket(name)
+			typ := name[0]
+			if typ == ltnValue {
 				return nil
 			}
-			v := b.Get(key)
-			if len(v) > 0 {
-				mv.preFlag = make([]byte, len(v))
-				copy(mv.preFlag, v)
+			if typ == ltnMode {
+				// restore the mode of the dropped tables
+				mb := tx2.Bucket([]byte(tableMode))
+				return b.ForEach(func(name, mode []byte) error {
+					if len(mode) == 1 && TableMode(mode[0]) != TableModeNone {
+						return mb.Put(dup(name), dup(mode))
+					}
+					return mb.Delete(name)
+				})
 			}
-			return nil
-		})
-		m.dataDb.View(func(tx *bolt.Tx) error {
-			b := tx.Bucket(getLocalTableName(ltnValue, tbName))
-			if b == nil {
-				return nil
+			tn := name[1:]
+			if typ == ltnFlag {
+				b2, err := tx2.CreateBucketIfNotExists(getLocalTableName(ltnFlag, tn))
+				if err != nil {
+					return err
+				}
+				return b.ForEach(func(key, flag []byte) error {
+					return putOrDelete(b2, dup(key), dup(flag))
+				})
 			}
-			v := b.Get(key)
-			if len(v) > 0 {
-				mv.preValue = make([]byte, len(v))
-				copy(mv.preValue, v)
+			b2, err := tx2.CreateBucketIfNotExists(getLocalTableName(ltnValue, tn))
+			if err != nil {
+				return err
 			}
-			return nil
+			bf := hb.Bucket(getLocalTableName(ltnFlag, tn))
+			return b.ForEach(func(key, value []byte) error {
+				// the key not written with flag is not in smt
+				var sv []byte
+				if bf != nil && len(bf.Get(key)) > 0 {
+					sv = value
+				}
+				err := smtSet(tx2, tn, key, sv)
+				if err != nil {
+					return err
+				}
+				return putOrDelete(b2, dup(key), dup(value))
+			})
 		})
+	})
+}
+
+// SetWithFlag set data with flag, enable rollback. empty value: delete the key
+func (m *Manager) SetWithFlag(flag, tbName, key, value []byte) error {
+	// log.Printf("SetWithFlag: flag:%x,tbName:%s,key:%x,len:%d\n", flag, tbName, key, len(value))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fs := m.getFlag(flag)
+	if fs == nil {
+		log.Printf("Set:not open flag:%x\n", flag)
+		return fmt.Errorf("not open flag")
 	}
-	mv.value = value
-	mv.withFlag = true
-	m.cache[mk] = mv
+	err := m.useFlagMode(fs, tbName)
+	if err != nil {
+		return err
+	}
+	fs.set(tbName, key, value)
 	return nil
 }
 
-// Set set data, unable rollback
+// DeleteWithFlag delete data with flag, enable rollback
+func (m *Manager) DeleteWithFlag(flag, tbName, key []byte) error {
+	return m.SetWithFlag(flag, tbName, key, nil)
+}
+
+// Set set data, unable rollback. empty value: delete the key
 func (m *Manager) Set(tbName, key, value []byte) error {
 	// log.Printf("Set: tbName:%s,key:%x,len:%d\n", tbName, key, len(value))
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	return m.dataDb.Update(func(tx *bolt.Tx) error {
+	return m.updateUnflagged([][]byte{tbName}, func(tx Tx) error {
 		b, err := tx.CreateBucketIfNotExists(getLocalTableName(ltnValue, tbName))
 		if err != nil {
 			log.Printf("fail to create bucket,%s\n", tbName)
 			return err
 		}
-		if len(value) == 0 {
-			return b.Delete(key)
+		err = clearExpire(tx, tbName, key)
+		if err != nil {
+			return err
 		}
-		err = b.Put(key, value)
+		err = putOrDelete(b, key, value)
 		if err != nil {
 			log.Println("fail to put:", key, err)
 			return err
@@ -554,31 +751,88 @@ func (m *Manager) Set(tbName, key, value []byte) error {
 	})
 }
 
-// Get get data
+// Delete delete data, unable rollback
+func (m *Manager) Delete(tbName, key []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.updateUnflagged([][]byte{tbName}, func(tx Tx) error {
+		b := tx.Bucket(getLocalTableName(ltnValue, tbName))
+		if b == nil {
+			return nil
+		}
+		err := clearExpire(tx, tbName, key)
+		if err != nil {
+			return err
+		}
+		err = b.Delete(key)
+		if err != nil {
+			log.Println("fail to delete:", key, err)
+		}
+		return err
+	})
+}
+
+// CompareAndSet set the value if the current value is expected, unable rollback.
+// expected=nil: the key not exist, value=nil: delete the key. Return true if the value is set.
+func (m *Manager) CompareAndSet(tbName, key, expected, value []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ok bool
+	err := m.updateUnflagged([][]byte{tbName}, func(tx Tx) error {
+		b, err := tx.CreateBucketIfNotExists(getLocalTableName(ltnValue, tbName))
+		if err != nil {
+			log.Printf("fail to create bucket,%s\n", tbName)
+			return err
+		}
+		if bytes.Compare(getLiveValue(tx, tbName, key), expected) != 0 {
+			return nil
+		}
+		err = clearExpire(tx, tbName, key)
+		if err != nil {
+			return err
+		}
+		err = putOrDelete(b, key, value)
+		if err != nil {
+			log.Println("fail to put:", key, err)
+			return err
+		}
+		ok = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// SetIfAbsent set the value if the key not exist, unable rollback. Return true if the value is set.
+func (m *Manager) SetIfAbsent(tbName, key, value []byte) (bool, error) {
+	return m.CompareAndSet(tbName, key, nil, value)
+}
+
+// Get get data, return nil if the key not exist(empty value is same as not exist).
+// It reads the data of the last opened flag(see GetLastFlag).
 func (m *Manager) Get(tbName, key []byte) []byte {
+	m.mu.Lock()
+	fs := m.lastFlag()
+	m.mu.Unlock()
+	return m.get(fs, tbName, key)
+}
+
+func (m *Manager) get(fs *flagState, tbName, key []byte) []byte {
 	mk := memKey{}
 	mk.TbName = hex.EncodeToString(tbName)
 	mk.Key = hex.EncodeToString(key)
 	m.mu.Lock()
-	v, ok := m.cache[mk]
+	v, ok := fs.lookup(mk)
 	m.mu.Unlock()
 	if ok {
 		// log.Printf("Get: tbName:%s,key:%x,len:%d\n", tbName, key, len(v.value))
 		return v.value
 	}
 	var out []byte
-	m.dataDb.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(getLocalTableName(ltnValue, tbName))
-		if b == nil {
-			// log.Printf("fail to get bucket:%s\n", tbName)
-			return nil
-		}
-		v := b.Get(key)
-		if len(v) > 0 {
-			out = make([]byte, len(v))
-			copy(out, v)
-		}
-		// log.Printf("read: tbName:%s,key:%x,len:%d\n", tbName, key, len(v))
+	m.dataDb.View(func(tx Tx) error {
+		out = getLiveValue(tx, tbName, key)
 		return nil
 	})
 	// log.Printf("Get: tbName:%s,key:%x,len:%d\n", tbName, key, len(out))
@@ -586,61 +840,182 @@ func (m *Manager) Get(tbName, key []byte) []byte {
 	return out
 }
 
-// Exist return true if the key exist
+// getValue get the value from the table of data.db, return nil if not exist
+func getValue(tx Tx, typ byte, tbName, key []byte) []byte {
+	b := tx.Bucket(getLocalTableName(typ, tbName))
+	if b == nil {
+		return nil
+	}
+	v := b.Get(key)
+	if len(v) == 0 {
+		return nil
+	}
+	return dup(v)
+}
+
+// Exist return true if the key exist. It reads the data of the last opened flag.
 func (m *Manager) Exist(tbName, key []byte) bool {
-	m.mu.Lock()
-	mk := memKey{}
-	mk.TbName = hex.EncodeToString(tbName)
-	mk.Key = hex.EncodeToString(key)
-	mv, ok := m.cache[mk]
-	m.mu.Unlock()
-	if ok && len(mv.value) > 0 {
-		return true
+	return len(m.Get(tbName, key)) > 0
+}
+
+// GetNextKey get next key(include the data of the opened flag), preKey=nil: get the first key
+func (m *Manager) GetNextKey(tbName, preKey []byte) []byte {
+	var start []byte
+	if len(preKey) > 0 {
+		start = append(dup(preKey), 0)
+	}
+	items, _ := m.scan(nil, tbName, start, nil, 1, false)
+	if len(items) == 0 {
+		return nil
 	}
+	return items[0].Key
+}
 
-	var exist bool
-	m.dataDb.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(getLocalTableName(ltnValue, tbName))
-		if b == nil {
-			return nil
-		}
-		v := b.Get(key)
-		if len(v) > 0 {
-			exist = true
-		}
+// Scan get the data of [start,end) in the order of key(include the data of the opened flag),
+// return at most limit items(limit<=0: no limit),
+// next is the first key not returned(nil if no more data), it can be used as start to get the rest.
+// start=nil: from the first key, end=nil: to the last key
+func (m *Manager) Scan(tbName, start, end []byte, limit int) ([]KV, []byte) {
+	return m.scan(nil, tbName, start, end, limit, false)
+}
+
+// ScanReverse get the data of [start,end) in the descending order of key(include the data of the opened flag),
+// return at most limit items(limit<=0: no limit),
+// next is the last key returned(nil if no more data), it can be used as end to get the rest.
+// start=nil: to the first key, end=nil: from the last key
+func (m *Manager) ScanReverse(tbName, start, end []byte, limit int) ([]KV, []byte) {
+	return m.scan(nil, tbName, start, end, limit, true)
+}
+
+// GetPrevKey get the previous key(include the data of the opened flag), key=nil: get the last key
+func (m *Manager) GetPrevKey(tbName, key []byte) []byte {
+	items, _ := m.scan(nil, tbName, nil, key, 1, true)
+	if len(items) == 0 {
 		return nil
-	})
+	}
+	return items[0].Key
+}
 
-	return exist
+// GetLastKey get the last key of the table(include the data of the opened flag)
+func (m *Manager) GetLastKey(tbName []byte) []byte {
+	return m.GetPrevKey(tbName, nil)
 }
 
-// GetNextKey get next key(visit database)
-func (m *Manager) GetNextKey(tbName, preKey []byte) []byte {
-	var out []byte
-	m.dataDb.View(func(tx *bolt.Tx) error {
+func inRange(key, start, end []byte) bool {
+	if len(start) > 0 && bytes.Compare(key, start) < 0 {
+		return false
+	}
+	if len(end) > 0 && bytes.Compare(key, end) >= 0 {
+		return false
+	}
+	return true
+}
+
+// scan merge the data of the flag(and its parents) and data.db, the data in cache is newer.
+// the keys with empty value are skipped(deleted). fs=nil: the last opened flag
+func (m *Manager) scan(fs *flagState, tbName, start, end []byte, limit int, reverse bool) ([]KV, []byte) {
+	m.mu.Lock()
+	if fs == nil {
+		fs = m.lastFlag()
+	}
+	// data.db is read without the lock, the writers are not blocked by the scan
+	cached := fs.snapshot(tbName, start, end, limit, reverse)
+	m.mu.Unlock()
+
+	var out []KV
+	var next []byte
+	m.dataDb.View(func(tx Tx) error {
+		var c Cursor
+		var k, v []byte
+		eb := tx.Bucket(getLocalTableName(ltnExpire, tbName))
+		now := ttlNow().UnixNano()
 		b := tx.Bucket(getLocalTableName(ltnValue, tbName))
-		if b == nil {
-			return nil
+		if b != nil {
+			c = b.Cursor()
+			switch {
+			case !reverse && len(start) > 0:
+				k, v = c.Seek(start)
+			case !reverse:
+				k, v = c.First()
+			case len(end) > 0:
+				k, v = c.Seek(end)
+				if k == nil {
+					k, v = c.Last()
+				} else {
+					k, v = c.Prev()
+				}
+			default:
+				k, v = c.Last()
+			}
 		}
-		c := b.Cursor()
-		var nk []byte
-		if len(preKey) > 0 {
-			nk, _ = c.Seek(preKey)
-			if len(nk) == 0 {
-				return nil
+		step := func() {
+			if reverse {
+				k, v = c.Prev()
+			} else {
+				k, v = c.Next()
 			}
-			nk, _ = c.Next()
-		} else {
-			nk, _ = c.First()
 		}
-
-		if nk == nil {
-			return nil
+		for {
+			if k != nil && !inRange(k, start, end) {
+				k = nil
+			}
+			var item KV
+			switch {
+			case k == nil && len(cached) == 0:
+				return nil
+			case k == nil:
+				item = cached[0]
+				cached = cached[1:]
+			case len(cached) == 0:
+				item = KV{k, v}
+				step()
+			default:
+				cmp := bytes.Compare(cached[0].Key, k)
+				if reverse {
+					cmp = -cmp
+				}
+				if cmp <= 0 {
+					item = cached[0]
+					cached = cached[1:]
+					if cmp == 0 {
+						step()
+					}
+				} else {
+					item = KV{k, v}
+					step()
+				}
+			}
+			if len(item.Value) == 0 || isExpired(eb, item.Key, now) {
+				continue
+			}
+			if limit > 0 && len(out) >= limit {
+				if reverse {
+					next = dup(out[len(out)-1].Key)
+				} else {
+					next = dup(item.Key)
+				}
+				return nil
+			}
+			out = append(out, KV{dup(item.Key), dup(item.Value)})
 		}
-		out = make([]byte, len(nk))
-		copy(out, nk)
-		return nil
 	})
+	return out, next
+}
 
-	return out
+// ScanPrefix get the data whose key has the prefix, same as Scan(tbName, prefix, end, limit),
+// end is the first key after all keys with the prefix
+func (m *Manager) ScanPrefix(tbName, prefix []byte, limit int) ([]KV, []byte) {
+	return m.Scan(tbName, prefix, prefixEnd(prefix), limit)
+}
+
+// prefixEnd return the first key after all keys with the prefix, nil if no such key
+func prefixEnd(prefix []byte) []byte {
+	out := dup(prefix)
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i]++
+		if out[i] != 0 {
+			return out[:i+1]
+		}
+	}
+	return nil
 }
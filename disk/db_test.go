@@ -2,6 +2,7 @@ package disk
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/boltdb/bolt"
 	"log"
 	"os"
@@ -24,7 +25,7 @@ var (
 func TestOpen(t *testing.T) {
 	log.Println("start test:", t.Name())
 	defer os.RemoveAll(testDir)
-	m, err := Open(testDir)
+	m, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -35,14 +36,14 @@ func TestOpen(t *testing.T) {
 func TestOpen2(t *testing.T) {
 	log.Println("start test:", t.Name())
 	defer os.RemoveAll(testDir)
-	m1, err := Open(testDir)
+	m1, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
 	}
 	m1.Close()
 
-	m2, err := Open(testDir)
+	m2, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -52,7 +53,7 @@ func TestOpen2(t *testing.T) {
 func TestSet(t *testing.T) {
 	log.Println("start test:", t.Name())
 	defer os.RemoveAll(testDir)
-	m, err := Open(testDir)
+	m, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -73,7 +74,7 @@ func TestSet(t *testing.T) {
 func TestSet1(t *testing.T) {
 	log.Println("start test:", t.Name())
 	defer os.RemoveAll(testDir)
-	m, err := Open(testDir)
+	m, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -90,7 +91,7 @@ func TestSet1(t *testing.T) {
 	}
 	m.Close()
 
-	m2, err := Open(testDir)
+	m2, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -106,7 +107,7 @@ func TestSet1(t *testing.T) {
 func TestExist(t *testing.T) {
 	log.Println("start test:", t.Name())
 	defer os.RemoveAll(testDir)
-	m, err := Open(testDir)
+	m, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -135,7 +136,7 @@ func TestExist(t *testing.T) {
 func TestSetWithFlag(t *testing.T) {
 	log.Println("start test:", t.Name())
 	defer os.RemoveAll(testDir)
-	m, err := Open(testDir)
+	m, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -164,7 +165,7 @@ func TestSetWithFlag2(t *testing.T) {
 	log.Println("start test:", t.Name())
 	defer os.RemoveAll(testDir)
 	os.RemoveAll(testDir)
-	m, err := Open(testDir)
+	m, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -188,7 +189,7 @@ func TestSetWithFlag2(t *testing.T) {
 	m.Commit(flag)
 	m.Close()
 
-	m2, err := Open(testDir)
+	m2, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -205,7 +206,7 @@ func TestSetWithFlag3(t *testing.T) {
 	log.Println("start test:", t.Name())
 	defer os.RemoveAll(testDir)
 	os.RemoveAll(testDir)
-	m, err := Open(testDir)
+	m, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -229,7 +230,7 @@ func TestSetWithFlag3(t *testing.T) {
 	m.Cancel(flag)
 	m.Close()
 
-	m2, err := Open(testDir)
+	m2, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -246,7 +247,7 @@ func TestSetWithFlag4(t *testing.T) {
 	log.Println("start test:", t.Name())
 	defer os.RemoveAll(testDir)
 	os.RemoveAll(testDir)
-	m, err := Open(testDir)
+	m, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -270,7 +271,7 @@ func TestSetWithFlag4(t *testing.T) {
 	m.Cancel(flag)
 	m.Close()
 
-	m2, err := Open(testDir)
+	m2, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -287,7 +288,7 @@ func TestRollback(t *testing.T) {
 	log.Println("start test:", t.Name())
 	defer os.RemoveAll(testDir)
 	os.RemoveAll(testDir)
-	m, err := Open(testDir)
+	m, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -322,7 +323,7 @@ func TestRollback2(t *testing.T) {
 	log.Println("start test:", t.Name())
 	defer os.RemoveAll(testDir)
 	os.RemoveAll(testDir)
-	m, err := Open(testDir)
+	m, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -352,7 +353,7 @@ func TestRollback2(t *testing.T) {
 	}
 	m.Close()
 
-	m2, err := Open(testDir)
+	m2, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -369,7 +370,7 @@ func TestRollback3(t *testing.T) {
 	log.Println("start test:", t.Name())
 	defer os.RemoveAll(testDir)
 	os.RemoveAll(testDir)
-	m, err := Open(testDir)
+	m, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -457,7 +458,7 @@ func TestRollback4(t *testing.T) {
 	log.Println("start test:", t.Name())
 	defer os.RemoveAll(testDir)
 	os.RemoveAll(testDir)
-	m, err := Open(testDir)
+	m, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -519,7 +520,7 @@ func TestRollback4(t *testing.T) {
 	m.Commit(flag3)
 	m.Close()
 
-	m2, err := Open(testDir)
+	m2, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -552,7 +553,7 @@ func TestRollback5(t *testing.T) {
 	log.Println("start test:", t.Name())
 	defer os.RemoveAll(testDir)
 	os.RemoveAll(testDir)
-	m, err := Open(testDir)
+	m, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -614,7 +615,7 @@ func TestRollback5(t *testing.T) {
 	m.Commit(flag3)
 	m.Close()
 
-	m2, err := Open(testDir)
+	m2, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -690,7 +691,7 @@ func TestCancel(t *testing.T) {
 	log.Println("start test:", t.Name())
 	defer os.RemoveAll(testDir)
 	os.RemoveAll(testDir)
-	m, err := Open(testDir)
+	m, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -714,7 +715,7 @@ func TestCancel(t *testing.T) {
 	m.Cancel(flag)
 	m.Close()
 
-	m2, err := Open(testDir)
+	m2, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -731,7 +732,7 @@ func TestCancel2(t *testing.T) {
 	log.Println("start test:", t.Name())
 	defer os.RemoveAll(testDir)
 	os.RemoveAll(testDir)
-	m, err := Open(testDir)
+	m, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -763,7 +764,7 @@ func TestCancel2(t *testing.T) {
 	}
 	m.Close()
 
-	m2, err := Open(testDir)
+	m2, err := Open(testDir, nil)
 	if err != nil {
 		t.Error("fail to open dir")
 		return
@@ -775,3 +776,562 @@ func TestCancel2(t *testing.T) {
 	}
 	m2.Close()
 }
+
+func TestRollbackTo(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Error("fail to open dir")
+		return
+	}
+	defer m.Close()
+	flags := [][]byte{flag, flag2, flag3}
+	values := [][]byte{value, value2, value3}
+	for i, f := range flags {
+		err = m.OpenFlag(f)
+		if err != nil {
+			t.Error("fail to open flag.", err)
+			return
+		}
+		err = m.SetWithFlag(f, tbName, key, values[i])
+		if err != nil {
+			t.Error("fail to set data.", err)
+			return
+		}
+		err = m.Commit(f)
+		if err != nil {
+			t.Error("fail to commit.", err)
+			return
+		}
+	}
+
+	err = m.RollbackTo([]byte("not exist"))
+	if err == nil {
+		t.Error("hope return error")
+	}
+	v := m.Get(tbName, key)
+	if bytes.Compare(v, value3) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value3, v)
+		return
+	}
+
+	err = m.RollbackTo(flag)
+	if err != nil {
+		t.Error("fail to rollback.", err)
+		return
+	}
+	v = m.Get(tbName, key)
+	if bytes.Compare(v, value) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value, v)
+		return
+	}
+	lf := m.GetLastFlag()
+	if bytes.Compare(lf, flag) != 0 {
+		t.Errorf("different last flag,hope:%s,get:%s", flag, lf)
+		return
+	}
+
+	// commit again after rollback
+	err = m.OpenFlag(flag2)
+	if err != nil {
+		t.Error("fail to open flag.", err)
+		return
+	}
+	err = m.SetWithFlag(flag2, tbName, key, value2)
+	if err != nil {
+		t.Error("fail to set data.", err)
+		return
+	}
+	err = m.Commit(flag2)
+	if err != nil {
+		t.Error("fail to commit.", err)
+		return
+	}
+	err = m.Rollback(flag2)
+	if err != nil {
+		t.Error("fail to rollback.", err)
+		return
+	}
+	v = m.Get(tbName, key)
+	if bytes.Compare(v, value) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value, v)
+	}
+}
+
+func TestRollbackTo2(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, &Options{HistoryMax: 1})
+	if err != nil {
+		t.Error("fail to open dir")
+		return
+	}
+	defer m.Close()
+	flags := [][]byte{flag, flag2, flag3}
+	values := [][]byte{value, value2, value3}
+	for i, f := range flags {
+		m.OpenFlag(f)
+		m.SetWithFlag(f, tbName, key, values[i])
+		err = m.Commit(f)
+		if err != nil {
+			t.Error("fail to commit.", err)
+			return
+		}
+	}
+
+	// the history of flag2 has been removed
+	err = m.RollbackTo(flag)
+	if err == nil {
+		t.Error("hope return error")
+	}
+	v := m.Get(tbName, key)
+	if bytes.Compare(v, value3) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value3, v)
+		return
+	}
+	err = m.RollbackTo(flag2)
+	if err != nil {
+		t.Error("fail to rollback.", err)
+		return
+	}
+	v = m.Get(tbName, key)
+	if bytes.Compare(v, value2) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value2, v)
+	}
+
+	// the history of flag2 has been removed, it can not be rolled back
+	err = m.Rollback(flag2)
+	if err == nil {
+		t.Error("hope return error")
+	}
+	v = m.Get(tbName, key)
+	if bytes.Compare(v, value2) != 0 || bytes.Compare(m.GetLastFlag(), flag2) != 0 {
+		t.Errorf("different value,hope:%s,get:%s,last flag:%s", value2, v, m.GetLastFlag())
+	}
+}
+
+func TestScan(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Error("fail to open dir")
+		return
+	}
+	defer m.Close()
+	for i := 0; i < 10; i++ {
+		k := []byte(fmt.Sprintf("a%02d", i))
+		err = m.Set(tbName, k, k)
+		if err != nil {
+			t.Error("fail to set data.", err)
+			return
+		}
+		k = []byte(fmt.Sprintf("b%02d", i))
+		m.Set(tbName, k, k)
+	}
+
+	items, next := m.Scan(tbName, []byte("a05"), []byte("b"), 3)
+	if len(items) != 3 || bytes.Compare(next, []byte("a08")) != 0 {
+		t.Errorf("error result,items:%d,next:%s", len(items), next)
+		return
+	}
+	for i, it := range items {
+		hope := []byte(fmt.Sprintf("a%02d", i+5))
+		if bytes.Compare(it.Key, hope) != 0 || bytes.Compare(it.Value, hope) != 0 {
+			t.Errorf("different key,hope:%s,get:%s", hope, it.Key)
+		}
+	}
+	items, next = m.Scan(tbName, next, []byte("b"), 3)
+	if len(items) != 2 || next != nil {
+		t.Errorf("error result,items:%d,next:%s", len(items), next)
+		return
+	}
+
+	items, next = m.Scan(tbName, nil, nil, 0)
+	if len(items) != 20 || next != nil {
+		t.Errorf("error result,items:%d,next:%s", len(items), next)
+		return
+	}
+	items, _ = m.Scan([]byte("not exist"), nil, nil, 0)
+	if len(items) != 0 {
+		t.Errorf("error result,items:%d", len(items))
+	}
+}
+
+func TestScanPrefix(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Error("fail to open dir")
+		return
+	}
+	defer m.Close()
+	keys := [][]byte{{1, 0xff}, {1, 0xff, 0}, {1, 0xff, 0xff}, {2}, {1}, {1, 0xfe}}
+	for _, k := range keys {
+		err = m.Set(tbName, k, value)
+		if err != nil {
+			t.Error("fail to set data.", err)
+			return
+		}
+	}
+	items, next := m.ScanPrefix(tbName, []byte{1, 0xff}, 0)
+	if len(items) != 3 || next != nil {
+		t.Errorf("error result,items:%d,next:%x", len(items), next)
+		return
+	}
+	items, next = m.ScanPrefix(tbName, []byte{1}, 2)
+	if len(items) != 2 || bytes.Compare(next, []byte{1, 0xff}) != 0 {
+		t.Errorf("error result,items:%d,next:%x", len(items), next)
+		return
+	}
+	items, next = m.Scan(tbName, next, prefixEnd([]byte{1}), 0)
+	if len(items) != 3 || next != nil {
+		t.Errorf("error result,items:%d,next:%x", len(items), next)
+	}
+}
+
+func TestScanReverse(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Error("fail to open dir")
+		return
+	}
+	defer m.Close()
+	if k := m.GetLastKey(tbName); k != nil {
+		t.Errorf("hope nil,get:%s", k)
+	}
+	for i := 0; i < 10; i++ {
+		k := []byte(fmt.Sprintf("a%02d", i))
+		err = m.Set(tbName, k, k)
+		if err != nil {
+			t.Error("fail to set data.", err)
+			return
+		}
+	}
+
+	items, next := m.ScanReverse(tbName, []byte("a02"), []byte("a08"), 4)
+	if len(items) != 4 || bytes.Compare(next, []byte("a04")) != 0 {
+		t.Errorf("error result,items:%d,next:%s", len(items), next)
+		return
+	}
+	for i, it := range items {
+		hope := []byte(fmt.Sprintf("a%02d", 7-i))
+		if bytes.Compare(it.Key, hope) != 0 {
+			t.Errorf("different key,hope:%s,get:%s", hope, it.Key)
+		}
+	}
+	items, next = m.ScanReverse(tbName, []byte("a02"), next, 4)
+	if len(items) != 2 || next != nil {
+		t.Errorf("error result,items:%d,next:%s", len(items), next)
+		return
+	}
+
+	k := m.GetLastKey(tbName)
+	if bytes.Compare(k, []byte("a09")) != 0 {
+		t.Errorf("different key,hope:a09,get:%s", k)
+	}
+	k = m.GetPrevKey(tbName, []byte("a05"))
+	if bytes.Compare(k, []byte("a04")) != 0 {
+		t.Errorf("different key,hope:a04,get:%s", k)
+	}
+	k = m.GetPrevKey(tbName, []byte("a045"))
+	if bytes.Compare(k, []byte("a04")) != 0 {
+		t.Errorf("different key,hope:a04,get:%s", k)
+	}
+	k = m.GetPrevKey(tbName, []byte("z"))
+	if bytes.Compare(k, []byte("a09")) != 0 {
+		t.Errorf("different key,hope:a09,get:%s", k)
+	}
+	k = m.GetPrevKey(tbName, []byte("a00"))
+	if k != nil {
+		t.Errorf("hope nil,get:%s", k)
+	}
+}
+
+func TestScanWithFlag(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Error("fail to open dir")
+		return
+	}
+	defer m.Close()
+	m.OpenFlag(flag3)
+	for i := 0; i < 5; i++ {
+		k := []byte(fmt.Sprintf("a%02d", i))
+		err = m.SetWithFlag(flag3, tbName, k, k)
+		if err != nil {
+			t.Error("fail to set data.", err)
+			return
+		}
+	}
+	m.Commit(flag3)
+	err = m.OpenFlag(flag)
+	if err != nil {
+		t.Error("fail to open flag.", err)
+		return
+	}
+	m.SetWithFlag(flag, tbName, []byte("a01"), nil)
+	m.SetWithFlag(flag, tbName, []byte("a02"), value)
+	m.SetWithFlag(flag, tbName, []byte("a05"), value)
+	m.SetWithFlag(flag, tbName, []byte("a06"), nil)
+
+	hope := []string{"a00", "a02", "a03", "a04", "a05"}
+	items, next := m.Scan(tbName, nil, nil, 0)
+	if len(items) != len(hope) || next != nil {
+		t.Errorf("error result,items:%d,next:%s", len(items), next)
+		return
+	}
+	for i, it := range items {
+		if string(it.Key) != hope[i] {
+			t.Errorf("different key,hope:%s,get:%s", hope[i], it.Key)
+		}
+	}
+	if bytes.Compare(items[1].Value, value) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value, items[1].Value)
+	}
+	items, next = m.ScanReverse(tbName, nil, nil, 2)
+	if len(items) != 2 || string(items[0].Key) != "a05" || string(next) != "a04" {
+		t.Errorf("error result,items:%d,next:%s", len(items), next)
+	}
+
+	var keys []string
+	for k := m.GetNextKey(tbName, nil); k != nil; k = m.GetNextKey(tbName, k) {
+		keys = append(keys, string(k))
+	}
+	if fmt.Sprint(keys) != fmt.Sprint(hope) {
+		t.Errorf("different keys,hope:%v,get:%v", hope, keys)
+	}
+	keys = nil
+	for k := m.GetLastKey(tbName); k != nil; k = m.GetPrevKey(tbName, k) {
+		keys = append([]string{string(k)}, keys...)
+	}
+	if fmt.Sprint(keys) != fmt.Sprint(hope) {
+		t.Errorf("different keys,hope:%v,get:%v", hope, keys)
+	}
+
+	m.Cancel(flag)
+	items, _ = m.Scan(tbName, nil, nil, 0)
+	if len(items) != 5 || string(items[1].Key) != "a01" {
+		t.Errorf("error result after cancel,items:%d", len(items))
+	}
+}
+
+func TestScanWithChildFlag(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	tbName2 := []byte("tbName2")
+	m.Set(tbName2, []byte("a03"), value)
+	m.OpenFlag(flag)
+	for i := 1; i < 5; i++ {
+		k := []byte(fmt.Sprintf("a%02d", i))
+		m.SetWithFlag(flag, tbName, k, k)
+	}
+	m.OpenFlagOn(flag, flag2)
+	id, _ := m.Savepoint(flag2)
+	m.SetWithFlag(flag2, tbName, []byte("a02"), nil)
+	m.SetWithFlag(flag2, tbName, []byte("a05"), value)
+	m.SetWithFlag(flag2, tbName, []byte("a00"), value)
+	m.SetWithFlag(flag2, tbName, []byte("a03"), value)
+
+	hope := []string{"a00", "a01", "a03", "a04", "a05"}
+	var keys []string
+	for k := m.GetNextKey(tbName, nil); k != nil; k = m.GetNextKey(tbName, k) {
+		keys = append(keys, string(k))
+	}
+	if fmt.Sprint(keys) != fmt.Sprint(hope) {
+		t.Errorf("different keys,hope:%v,get:%v", hope, keys)
+	}
+	// scan page by page, the cache is read at most limit+1 keys every page
+	keys = nil
+	for start := []byte(nil); ; {
+		items, next, _ := m.ScanWithFlag(flag2, tbName, start, nil, 2, false)
+		for _, it := range items {
+			keys = append(keys, string(it.Key))
+		}
+		if next == nil {
+			break
+		}
+		start = next
+	}
+	if fmt.Sprint(keys) != fmt.Sprint(hope) {
+		t.Errorf("different keys of pages,hope:%v,get:%v", hope, keys)
+	}
+	items, next, _ := m.ScanWithFlag(flag2, tbName, []byte("a01"), []byte("a05"), 2, true)
+	if len(items) != 2 || string(items[0].Key) != "a04" || bytes.Compare(items[1].Value, value) != 0 || string(next) != "a03" {
+		t.Errorf("error result,items:%v,next:%s", items, next)
+	}
+	items, _, _ = m.ScanWithFlag(flag, tbName, nil, nil, 0, false)
+	if len(items) != 4 || string(items[1].Key) != "a02" {
+		t.Errorf("hope the parent flag not include the data of child:%v", items)
+	}
+
+	m.RollbackToSavepoint(flag2, id)
+	items, _, _ = m.ScanWithFlag(flag2, tbName, nil, nil, 0, false)
+	if len(items) != 4 || string(items[0].Key) != "a01" || string(items[2].Key) != "a03" ||
+		bytes.Compare(items[2].Value, []byte("a03")) != 0 {
+		t.Errorf("error result after rollback to savepoint:%v", items)
+	}
+	if items, _ = m.Scan(tbName2, nil, nil, 0); len(items) != 1 {
+		t.Errorf("error result of other table:%v", items)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Error("fail to open dir")
+		return
+	}
+	defer m.Close()
+	err = m.Delete(tbName, key)
+	if err != nil {
+		t.Error("fail to delete not exist key.", err)
+		return
+	}
+	m.Set(tbName, key, value)
+	err = m.Delete(tbName, key)
+	if err != nil {
+		t.Error("fail to delete.", err)
+		return
+	}
+	if m.Exist(tbName, key) || m.Get(tbName, key) != nil {
+		t.Error("hope not exist")
+	}
+	m.Set(tbName, key, value)
+	m.Set(tbName, key, []byte{})
+	if m.Exist(tbName, key) || m.Get(tbName, key) != nil {
+		t.Error("hope not exist")
+	}
+}
+
+func TestCompareAndSet(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	ok, err := m.SetIfAbsent(tbName, key, value)
+	if err != nil || !ok {
+		t.Fatal("fail to set absent key.", ok, err)
+	}
+	ok, _ = m.SetIfAbsent(tbName, key, value2)
+	if ok || bytes.Compare(m.Get(tbName, key), value) != 0 {
+		t.Error("hope not set the exist key")
+	}
+	ok, _ = m.CompareAndSet(tbName, key, value2, value3)
+	if ok || bytes.Compare(m.Get(tbName, key), value) != 0 {
+		t.Error("hope not set with different expected value")
+	}
+	ok, _ = m.CompareAndSet(tbName, key, value, value2)
+	if !ok || bytes.Compare(m.Get(tbName, key), value2) != 0 {
+		t.Error("fail to compare and set")
+	}
+	ok, _ = m.CompareAndSet(tbName, key, value2, nil)
+	if !ok || m.Exist(tbName, key) {
+		t.Error("hope the key is deleted")
+	}
+	ok, _ = m.CompareAndSet(tbName, key, nil, value3)
+	if !ok || bytes.Compare(m.Get(tbName, key), value3) != 0 {
+		t.Error("fail to set with nil expected value")
+	}
+
+	flagTable := []byte("flagTable")
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, flagTable, key, value)
+	if _, err = m.SetIfAbsent(flagTable, key2, value); err == nil {
+		t.Error("hope error on flagged table")
+	}
+}
+
+func TestDeleteWithFlag(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Error("fail to open dir")
+		return
+	}
+	defer m.Close()
+	key2 := []byte("key2")
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key, value)
+	m.Commit(flag)
+
+	m.OpenFlag(flag2)
+	err = m.DeleteWithFlag(flag2, tbName, key)
+	if err != nil {
+		t.Error("fail to delete.", err)
+		return
+	}
+	m.SetWithFlag(flag2, tbName, key2, []byte{})
+	if m.Exist(tbName, key) || m.Get(tbName, key) != nil {
+		t.Error("hope not exist")
+	}
+	err = m.Commit(flag2)
+	if err != nil {
+		t.Error("fail to commit.", err)
+		return
+	}
+	if m.Exist(tbName, key) || m.Exist(tbName, key2) {
+		t.Error("hope not exist")
+	}
+	m.dataDb.View(func(tx Tx) error {
+		b := tx.Bucket(getLocalTableName(ltnValue, tbName))
+		if b.Get(key) != nil || b.Get(key2) != nil {
+			t.Error("hope the keys are deleted from data.db")
+		}
+		return nil
+	})
+
+	m.OpenFlag(flag3)
+	m.SetWithFlag(flag3, tbName, key2, value2)
+	m.Commit(flag3)
+	err = m.RollbackTo(flag)
+	if err != nil {
+		t.Error("fail to rollback.", err)
+		return
+	}
+	v := m.Get(tbName, key)
+	if bytes.Compare(v, value) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value, v)
+	}
+	if m.Exist(tbName, key2) {
+		t.Error("hope not exist")
+	}
+	items, _ := m.Scan(tbName, nil, nil, 0)
+	if len(items) != 1 {
+		t.Errorf("error items:%d", len(items))
+	}
+	m.dataDb.View(func(tx Tx) error {
+		b := tx.Bucket(getLocalTableName(ltnValue, tbName))
+		if b.Get(key2) != nil {
+			t.Error("hope the key is deleted from data.db")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,233 @@
+package disk
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"sync"
+)
+
+// Engine the storage engine of a database file(data.db, flag.db and history.db).
+// The data is kept in buckets(may be nested) of ordered keys, same as bolt.
+type Engine interface {
+	// Begin start a transaction, only one writable transaction at a time
+	Begin(writable bool) (Tx, error)
+	// View run fn in a read-only transaction
+	View(fn func(tx Tx) error) error
+	// Update run fn in a writable transaction, commit if fn return nil
+	Update(fn func(tx Tx) error) error
+	Close() error
+}
+
+// Tx the transaction of engine, it is the root bucket which only has buckets
+type Tx interface {
+	Bucket
+	Commit() error
+	Rollback() error
+}
+
+// Bucket the collection of ordered keys and nested buckets.
+// The slices returned are valid in the transaction, the slices passed to Put are not modified.
+type Bucket interface {
+	// Get return nil if not exist or it is a bucket
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	// Cursor iterate the keys and buckets(value is nil) in the order of key
+	Cursor() Cursor
+	// ForEach call fn for all keys and buckets(value is nil), stop if fn return error
+	ForEach(fn func(k, v []byte) error) error
+	// Bucket return the nested bucket, nil if not exist
+	Bucket(name []byte) Bucket
+	CreateBucket(name []byte) (Bucket, error)
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+	DeleteBucket(name []byte) error
+}
+
+// Cursor the cursor of bucket, key is nil at the end
+type Cursor interface {
+	First() (key []byte, value []byte)
+	Last() (key []byte, value []byte)
+	Next() (key []byte, value []byte)
+	Prev() (key []byte, value []byte)
+	// Seek move to the key, or the next key if not exist
+	Seek(seek []byte) (key []byte, value []byte)
+}
+
+// the name of the engines
+const (
+	EngineBolt   = "bolt"
+	EngineMemory = "memory"
+	EngineLog    = "log"
+)
+
+// EngineFactory open the engine of the file
+type EngineFactory func(fn string) (Engine, error)
+
+var (
+	engineMu  sync.Mutex
+	engines   = make(map[string]EngineFactory)
+	errTxRoot = fmt.Errorf("the root of transaction only has buckets")
+)
+
+func init() {
+	RegisterEngine(EngineBolt, openBolt)
+	RegisterEngine(EngineMemory, func(fn string) (Engine, error) {
+		return newMemEngine(), nil
+	})
+	RegisterEngine(EngineLog, openLog)
+}
+
+// RegisterEngine register the engine, Options.Engine select it by name
+func RegisterEngine(name string, factory EngineFactory) {
+	engineMu.Lock()
+	defer engineMu.Unlock()
+	engines[name] = factory
+}
+
+func openEngine(name, fn string) (Engine, error) {
+	engineMu.Lock()
+	factory := engines[name]
+	engineMu.Unlock()
+	if factory == nil {
+		return nil, fmt.Errorf("unknown engine:%s", name)
+	}
+	return factory(fn)
+}
+
+// boltEngine the engine of bolt
+type boltEngine struct {
+	db *bolt.DB
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+type boltBucket struct {
+	b *bolt.Bucket
+}
+
+func openBolt(fn string) (Engine, error) {
+	db, err := bolt.Open(fn, fileMode, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &boltEngine{db}, nil
+}
+
+func (e *boltEngine) Begin(writable bool) (Tx, error) {
+	tx, err := e.db.Begin(writable)
+	if err != nil {
+		return nil, err
+	}
+	return &boltTx{tx}, nil
+}
+
+func (e *boltEngine) View(fn func(tx Tx) error) error {
+	return e.db.View(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx})
+	})
+}
+
+func (e *boltEngine) Update(fn func(tx Tx) error) error {
+	return e.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx})
+	})
+}
+
+func (e *boltEngine) Close() error {
+	return e.db.Close()
+}
+
+func newBoltBucket(b *bolt.Bucket) Bucket {
+	if b == nil {
+		return nil
+	}
+	return &boltBucket{b}
+}
+
+func (t *boltTx) Get(key []byte) []byte {
+	return nil
+}
+
+func (t *boltTx) Put(key, value []byte) error {
+	return errTxRoot
+}
+
+func (t *boltTx) Delete(key []byte) error {
+	return errTxRoot
+}
+
+func (t *boltTx) Cursor() Cursor {
+	return t.tx.Cursor()
+}
+
+func (t *boltTx) ForEach(fn func(k, v []byte) error) error {
+	return t.tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+		return fn(name, nil)
+	})
+}
+
+func (t *boltTx) Bucket(name []byte) Bucket {
+	return newBoltBucket(t.tx.Bucket(name))
+}
+
+func (t *boltTx) CreateBucket(name []byte) (Bucket, error) {
+	b, err := t.tx.CreateBucket(name)
+	return newBoltBucket(b), err
+}
+
+func (t *boltTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	return newBoltBucket(b), err
+}
+
+func (t *boltTx) DeleteBucket(name []byte) error {
+	return t.tx.DeleteBucket(name)
+}
+
+func (t *boltTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *boltTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+func (b *boltBucket) Get(key []byte) []byte {
+	return b.b.Get(key)
+}
+
+func (b *boltBucket) Put(key, value []byte) error {
+	return b.b.Put(key, value)
+}
+
+func (b *boltBucket) Delete(key []byte) error {
+	return b.b.Delete(key)
+}
+
+func (b *boltBucket) Cursor() Cursor {
+	return b.b.Cursor()
+}
+
+func (b *boltBucket) ForEach(fn func(k, v []byte) error) error {
+	return b.b.ForEach(fn)
+}
+
+func (b *boltBucket) Bucket(name []byte) Bucket {
+	return newBoltBucket(b.b.Bucket(name))
+}
+
+func (b *boltBucket) CreateBucket(name []byte) (Bucket, error) {
+	nb, err := b.b.CreateBucket(name)
+	return newBoltBucket(nb), err
+}
+
+func (b *boltBucket) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	nb, err := b.b.CreateBucketIfNotExists(name)
+	return newBoltBucket(nb), err
+}
+
+func (b *boltBucket) DeleteBucket(name []byte) error {
+	return b.b.DeleteBucket(name)
+}
@@ -0,0 +1,358 @@
+package disk
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"testing"
+)
+
+func testEngine(t *testing.T, e Engine) {
+	err := e.Update(func(tx Tx) error {
+		b, err := tx.CreateBucket(tbName)
+		if err != nil {
+			return err
+		}
+		for i := 9; i >= 0; i-- {
+			err = b.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+			if err != nil {
+				return err
+			}
+		}
+		_, err = b.CreateBucket([]byte("key5.5"))
+		return err
+	})
+	if err != nil {
+		t.Fatal("fail to update.", err)
+	}
+	tx, err := e.Begin(false)
+	if err != nil {
+		t.Fatal("fail to begin.", err)
+	}
+	if tx.Bucket(tbName) == nil || tx.Commit() == nil {
+		t.Error("hope error when commit read-only transaction")
+	}
+	tx.Rollback()
+	// the changes are discarded if fn return error
+	e.Update(func(tx Tx) error {
+		b := tx.Bucket(tbName)
+		b.Put([]byte("key10"), value)
+		b.Delete([]byte("key1"))
+		tx.CreateBucket(key)
+		return fmt.Errorf("discard")
+	})
+	e.View(func(tx Tx) error {
+		if tx.Bucket(key) != nil {
+			t.Error("hope the bucket not exist")
+		}
+		b := tx.Bucket(tbName)
+		if b.Get([]byte("key10")) != nil || bytes.Compare(b.Get([]byte("key1")), []byte("value1")) != 0 {
+			t.Error("hope the changes are discarded")
+		}
+		if b.Get([]byte("key5.5")) != nil || b.Bucket([]byte("key5.5")) == nil {
+			t.Error("error nested bucket")
+		}
+		if tx.Put(key, value) == nil || b.Put(key, value) == nil {
+			t.Error("hope error when put in read-only transaction")
+		}
+		c := b.Cursor()
+		var keys []string
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		if len(keys) != 11 || keys[0] != "key0" || keys[6] != "key5.5" || keys[10] != "key9" {
+			t.Error("error order of keys:", keys)
+		}
+		k, v := c.Seek([]byte("key5.1"))
+		if string(k) != "key5.5" || v != nil {
+			t.Errorf("error seek:%s", k)
+		}
+		k, _ = c.Prev()
+		if string(k) != "key5" {
+			t.Errorf("error prev:%s", k)
+		}
+		k, _ = c.Last()
+		if string(k) != "key9" {
+			t.Errorf("error last:%s", k)
+		}
+		if k, _ = c.Next(); k != nil {
+			t.Errorf("hope the end:%s", k)
+		}
+		return nil
+	})
+}
+
+func TestEngine(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	os.Mkdir(testDir, fileMode)
+	for _, name := range []string{EngineBolt, EngineMemory, EngineLog} {
+		e, err := openEngine(name, path.Join(testDir, name))
+		if err != nil {
+			t.Fatal("fail to open engine:", name, err)
+		}
+		t.Run(name, func(t *testing.T) { testEngine(t, e) })
+		e.Close()
+	}
+	if _, err := openEngine("unknown", ""); err == nil {
+		t.Error("hope error of unknown engine")
+	}
+}
+
+func TestMemoryEngine(t *testing.T) {
+	log.Println("start test:", t.Name())
+	dir := testDir + "_memory"
+	m, err := Open(dir, &Options{Engine: EngineMemory})
+	if err != nil {
+		t.Fatal("fail to open", err)
+	}
+	defer m.Close()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("hope not create dir")
+	}
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key3, value3)
+	m.SetWithFlag(flag, tbName, key, value)
+	m.Commit(flag)
+	m.OpenFlag(flag2)
+	m.SetWithFlag(flag2, tbName, key, value2)
+	m.SetWithFlag(flag2, tbName, key2, value2)
+	m.Commit(flag2)
+	items, _ := m.Scan(tbName, nil, nil, 0)
+	if len(items) != 3 || bytes.Compare(items[1].Key, key2) != 0 {
+		t.Error("error scan:", items)
+	}
+	v, err := m.GetAt(flag, tbName, key)
+	if err != nil || bytes.Compare(v, value) != 0 {
+		t.Errorf("different value,hope:%s,get:%s,%v", value, v, err)
+	}
+	root, _ := m.GetStateRoot(flag)
+	err = m.Rollback(flag2)
+	if err != nil {
+		t.Fatal("fail to rollback.", err)
+	}
+	v = m.Get(tbName, key)
+	if bytes.Compare(v, value) != 0 || m.Exist(tbName, key2) || !m.Exist(tbName, key3) {
+		t.Errorf("different value after rollback,hope:%s,get:%s", value, v)
+	}
+	v, proof, _ := m.GetWithProof(tbName, key)
+	if bytes.Compare(proof.Root, root) != 0 || !VerifyProof(root, tbName, key, v, proof) {
+		t.Error("fail to verify proof after rollback")
+	}
+}
+
+func TestLogEngine(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	os.Mkdir(testDir, fileMode)
+	defer func(size, compact int64) {
+		logSegmentSize, logCompactSize = size, compact
+	}(logSegmentSize, logCompactSize)
+	logSegmentSize = 1024
+	logCompactSize = 1 << 30
+	fn := path.Join(testDir, "data.db")
+	e, err := openEngine(EngineLog, fn)
+	if err != nil {
+		t.Fatal("fail to open engine.", err)
+	}
+	for i := 0; i < 100; i++ {
+		err = e.Update(func(tx Tx) error {
+			b, _ := tx.CreateBucketIfNotExists(tbName)
+			b.Put([]byte(fmt.Sprintf("key%d", i%10)), []byte(fmt.Sprintf("value%d", i)))
+			if i == 50 {
+				tx.CreateBucket(key)
+			}
+			if i == 60 {
+				tx.DeleteBucket(key)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal("fail to update.", err)
+		}
+	}
+	check := func(e Engine) {
+		e.View(func(tx Tx) error {
+			if tx.Bucket(key) != nil {
+				t.Error("hope the bucket deleted")
+			}
+			b := tx.Bucket(tbName)
+			var n int
+			b.ForEach(func(k, v []byte) error {
+				if string(v) != fmt.Sprintf("value9%s", k[3:]) {
+					t.Errorf("error value,key:%s,value:%s", k, v)
+				}
+				n++
+				return nil
+			})
+			if n != 10 {
+				t.Error("error number of keys:", n)
+			}
+			return nil
+		})
+	}
+	check(e)
+	files, _ := ioutil.ReadDir(fn)
+	if len(files) < 3 {
+		t.Error("hope several segments:", len(files))
+	}
+	ls := e.(*memEngine).log
+	err = ls.compact()
+	if err != nil {
+		t.Fatal("fail to compact.", err)
+	}
+	check(e)
+	files, _ = ioutil.ReadDir(fn)
+	if len(files) != 2 || path.Ext(files[0].Name()) != logSnapExt {
+		t.Error("hope only the snapshot and the active segment after compact:", len(files))
+	}
+	e.Update(func(tx Tx) error {
+		return tx.Bucket(tbName).Put([]byte("key10"), value)
+	})
+	e.Close()
+
+	// the broken batch at the end of log is discarded
+	last := path.Join(fn, files[1].Name())
+	info, _ := os.Stat(last)
+	f, _ := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, fileMode)
+	f.Write([]byte{0, 0, 1, 0, 1, 2, 3})
+	f.Close()
+	e, err = openEngine(EngineLog, fn)
+	if err != nil {
+		t.Fatal("fail to open engine.", err)
+	}
+	defer e.Close()
+	if info2, _ := os.Stat(last); info2.Size() != info.Size() {
+		t.Error("hope truncate the broken batch:", info.Size(), info2.Size())
+	}
+	e.Update(func(tx Tx) error {
+		return tx.Bucket(tbName).Delete([]byte("key10"))
+	})
+	check(e)
+}
+
+func TestLogEngineManager(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	opt := &Options{Engine: EngineLog}
+	m, err := Open(testDir, opt)
+	if err != nil {
+		t.Fatal("fail to open", err)
+	}
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key3, value3)
+	m.SetWithFlag(flag, tbName, key, value)
+	m.Commit(flag)
+	m.OpenFlag(flag2)
+	m.SetWithFlag(flag2, tbName, key, value2)
+	m.SetWithFlag(flag2, tbName, key2, value2)
+	m.Commit(flag2)
+	m.Close()
+
+	m, err = Open(testDir, opt)
+	if err != nil {
+		t.Fatal("fail to open", err)
+	}
+	defer m.Close()
+	if k := m.GetNextKey(tbName, key); bytes.Compare(k, key2) != 0 {
+		t.Errorf("error next key:%s", k)
+	}
+	v := m.Get(tbName, key)
+	if bytes.Compare(v, value2) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value2, v)
+	}
+	err = m.Rollback(flag2)
+	if err != nil {
+		t.Fatal("fail to rollback.", err)
+	}
+	v = m.Get(tbName, key)
+	if bytes.Compare(v, value) != 0 || m.Exist(tbName, key2) || !m.Exist(tbName, key3) {
+		t.Errorf("different value after rollback,hope:%s,get:%s", value, v)
+	}
+	if k := m.GetNextKey(tbName, key); bytes.Compare(k, key3) != 0 {
+		t.Errorf("error next key:%s", k)
+	}
+	n, bad, err := m.VerifyChain()
+	if err != nil || n != 1 || bad != nil {
+		t.Error("fail to verify chain:", n, bad, err)
+	}
+}
+
+func TestLogCompact(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	os.Mkdir(testDir, fileMode)
+	defer func(size, compact int64) {
+		logSegmentSize, logCompactSize = size, compact
+	}(logSegmentSize, logCompactSize)
+	logSegmentSize = 4096
+	logCompactSize = 8192
+	fn := path.Join(testDir, "data.db")
+	e, err := openEngine(EngineLog, fn)
+	if err != nil {
+		t.Fatal("fail to open engine.", err)
+	}
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			e.View(func(tx Tx) error {
+				b := tx.Bucket(tbName)
+				if b == nil {
+					return nil
+				}
+				return b.ForEach(func(k, v []byte) error {
+					if len(v) == 0 {
+						t.Errorf("fail to read value of key:%s", k)
+					}
+					return nil
+				})
+			})
+		}
+	}()
+	for i := 0; i < 3000; i++ {
+		e.Update(func(tx Tx) error {
+			b, _ := tx.CreateBucketIfNotExists(tbName)
+			return b.Put([]byte(fmt.Sprintf("key%d", i%37)), []byte(fmt.Sprintf("value%d", i)))
+		})
+	}
+	close(done)
+	e.Close()
+	files, _ := ioutil.ReadDir(fn)
+	if len(files) == 0 || path.Ext(files[0].Name()) != logSnapExt {
+		t.Error("hope compact in background")
+	}
+
+	e, err = openEngine(EngineLog, fn)
+	if err != nil {
+		t.Fatal("fail to open engine.", err)
+	}
+	defer e.Close()
+	var n int
+	e.View(func(tx Tx) error {
+		return tx.Bucket(tbName).ForEach(func(k, v []byte) error {
+			var i, j int
+			fmt.Sscanf(string(k), "key%d", &i)
+			fmt.Sscanf(string(v), "value%d", &j)
+			if j%37 != i || j < 3000-37 {
+				t.Errorf("error value,key:%s,value:%s", k, v)
+			}
+			n++
+			return nil
+		})
+	})
+	if n != 37 {
+		t.Error("error number of keys:", n)
+	}
+}
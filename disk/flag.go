@@ -0,0 +1,275 @@
+package disk
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// flagState the opened flag. The flag is opened on the last committed flag(parent=nil)
+// or on another opened flag, it reads the data of its parents.
+type flagState struct {
+	flag   []byte
+	parent *flagState
+	cache  map[memKey]*memValue
+	// savepoints of the flag
+	savepoints []*savepoint
+	// tables the tables written by the flag, their mode is flagged(see useFlagMode)
+	tables map[string]bool
+	// dropped the tables dropped by the flag, they are removed from data.db when the flag is committed
+	dropped map[string]bool
+	// index the keys of cache in order, tbName -> skipList(key -> *memValue), used by scan
+	index map[string]*skipList
+}
+
+// lookup find the key in the cache of the flag and its parents
+func (fs *flagState) lookup(mk memKey) (*memValue, bool) {
+	for it := fs; it != nil; it = it.parent {
+		if v, ok := it.cache[mk]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// root return the flag opened on the last committed flag
+func (fs *flagState) root() *flagState {
+	out := fs
+	for out.parent != nil {
+		out = out.parent
+	}
+	return out
+}
+
+// isOn return true if the flag is opened on p(directly or not)
+func (fs *flagState) isOn(p *flagState) bool {
+	for it := fs.parent; it != nil; it = it.parent {
+		if it == p {
+			return true
+		}
+	}
+	return false
+}
+
+// set set the data in the cache of the flag, empty value: delete the key.
+// The data is copied, the key is kept by the index, the caller may reuse its buffers.
+func (fs *flagState) set(tbName, key, value []byte) {
+	mk := memKey{}
+	mk.TbName = hex.EncodeToString(tbName)
+	mk.Key = hex.EncodeToString(key)
+	mv, ok := fs.cache[mk]
+	fs.saveUndo(mk, mv)
+	if !ok {
+		mv = new(memValue)
+		mv.tbName = dup(tbName)
+		mv.key = dup(key)
+	}
+	mv.value = nil
+	if len(value) > 0 {
+		mv.value = dup(value)
+	}
+	mv.withFlag = true
+	fs.put(mk, mv)
+}
+
+// put set the value in the cache and the index
+func (fs *flagState) put(mk memKey, mv *memValue) {
+	fs.cache[mk] = mv
+	l := fs.index[mk.TbName]
+	if l == nil {
+		l = newSkipList()
+		fs.index[mk.TbName] = l
+	}
+	l.Put(mv.key, mv)
+}
+
+// remove delete the key from the cache and the index
+func (fs *flagState) remove(mk memKey) {
+	mv, ok := fs.cache[mk]
+	if !ok {
+		return
+	}
+	delete(fs.cache, mk)
+	if l := fs.index[mk.TbName]; l != nil {
+		l.Delete(mv.key)
+	}
+}
+
+// snapshot return the keys in [start,end) of the cache of the flag and its parents, in the order of scan.
+// limit>0: stop after limit+1 keys with value, the keys after them are not read by the scan. m.mu must be held.
+func (fs *flagState) snapshot(tbName, start, end []byte, limit int, reverse bool) []KV {
+	var out []KV
+	var n int
+	ci := fs.iter(tbName, start, end, reverse)
+	for mv := ci.peek(); mv != nil; mv = ci.peek() {
+		out = append(out, KV{mv.key, mv.value})
+		ci.next()
+		if len(mv.value) == 0 {
+			continue
+		}
+		n++
+		if limit > 0 && n > limit {
+			break
+		}
+	}
+	return out
+}
+
+// cacheIter iterate the keys of a table in the cache of the flag and its parents, in the order of key.
+// The value of the flag is newer than its parents.
+type cacheIter struct {
+	lists      []*skipList
+	nodes      []*skipNode
+	start, end []byte
+	reverse    bool
+}
+
+// iter return the iterator of the keys in [start,end), fs=nil: empty iterator. m.mu must be held while using it.
+func (fs *flagState) iter(tbName, start, end []byte, reverse bool) *cacheIter {
+	out := &cacheIter{start: start, end: end, reverse: reverse}
+	tb := hex.EncodeToString(tbName)
+	for it := fs; it != nil; it = it.parent {
+		l := it.index[tb]
+		if l == nil {
+			continue
+		}
+		var n *skipNode
+		switch {
+		case !reverse && len(start) > 0:
+			n = l.Seek(start)
+		case !reverse:
+			n = l.First()
+		case len(end) > 0:
+			n = l.Before(end)
+		default:
+			n = l.Last()
+		}
+		out.lists = append(out.lists, l)
+		out.nodes = append(out.nodes, out.check(n))
+	}
+	return out
+}
+
+func (ci *cacheIter) check(n *skipNode) *skipNode {
+	if n != nil && !inRange(n.key, ci.start, ci.end) {
+		return nil
+	}
+	return n
+}
+
+// peek return the current value, nil if no more key
+func (ci *cacheIter) peek() *memValue {
+	var out *skipNode
+	for _, n := range ci.nodes {
+		if n == nil {
+			continue
+		}
+		if out == nil {
+			out = n
+			continue
+		}
+		cmp := bytes.Compare(n.key, out.key)
+		if ci.reverse {
+			cmp = -cmp
+		}
+		// the same key in the parent is skipped
+		if cmp < 0 {
+			out = n
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	return out.value.(*memValue)
+}
+
+// next move to the next key
+func (ci *cacheIter) next() {
+	cur := ci.peek()
+	if cur == nil {
+		return
+	}
+	for i, n := range ci.nodes {
+		if n == nil || bytes.Compare(n.key, cur.key) != 0 {
+			continue
+		}
+		if ci.reverse {
+			ci.nodes[i] = ci.check(ci.lists[i].Before(n.key))
+		} else {
+			ci.nodes[i] = ci.check(n.next[0])
+		}
+	}
+}
+
+func (m *Manager) getFlag(flag []byte) *flagState {
+	if len(flag) == 0 {
+		return nil
+	}
+	for _, fs := range m.flags {
+		if bytes.Compare(fs.flag, flag) == 0 {
+			return fs
+		}
+	}
+	return nil
+}
+
+// hasChild return true if a flag is opened on fs. m.mu must be held.
+func (m *Manager) hasChild(fs *flagState) bool {
+	for _, it := range m.flags {
+		if it.parent == fs {
+			return true
+		}
+	}
+	return false
+}
+
+// lastFlag return the last opened flag, nil if not exist. It is read by Get/Exist/Scan/GetLastFlag.
+func (m *Manager) lastFlag() *flagState {
+	if len(m.flags) == 0 {
+		return nil
+	}
+	return m.flags[len(m.flags)-1]
+}
+
+// GetOpenedFlags return the opened flags, in the order of opening
+func (m *Manager) GetOpenedFlags() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out [][]byte
+	for _, fs := range m.flags {
+		out = append(out, fs.flag)
+	}
+	return out
+}
+
+// GetWithFlag get data in the view of the opened flag
+func (m *Manager) GetWithFlag(flag, tbName, key []byte) ([]byte, error) {
+	m.mu.Lock()
+	fs := m.getFlag(flag)
+	m.mu.Unlock()
+	if fs == nil {
+		log.Printf("Get:not open flag:%x\n", flag)
+		return nil, fmt.Errorf("not open flag")
+	}
+	return m.get(fs, tbName, key), nil
+}
+
+// ExistWithFlag return true if the key exist in the view of the opened flag
+func (m *Manager) ExistWithFlag(flag, tbName, key []byte) (bool, error) {
+	v, err := m.GetWithFlag(flag, tbName, key)
+	return len(v) > 0, err
+}
+
+// ScanWithFlag scan the keys in [start,end) in the view of the opened flag, same as Scan/ScanReverse
+func (m *Manager) ScanWithFlag(flag, tbName, start, end []byte, limit int, reverse bool) ([]KV, []byte, error) {
+	m.mu.Lock()
+	fs := m.getFlag(flag)
+	m.mu.Unlock()
+	if fs == nil {
+		log.Printf("Scan:not open flag:%x\n", flag)
+		return nil, nil, fmt.Errorf("not open flag")
+	}
+	items, next := m.scan(fs, tbName, start, end, limit, reverse)
+	return items, next, nil
+}
@@ -0,0 +1,189 @@
+package disk
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestSiblingFlags(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	flag0 := []byte("flag0")
+	m.OpenFlag(flag0)
+	m.SetWithFlag(flag0, tbName, key, value)
+	m.Commit(flag0)
+
+	// flag and flag2 are both opened on the last committed flag
+	err = m.OpenFlag(flag)
+	if err != nil {
+		t.Fatal("fail to open flag.", err)
+	}
+	err = m.OpenFlag(flag2)
+	if err != nil {
+		t.Fatal("fail to open sibling flag.", err)
+	}
+	if m.OpenFlag(flag) == nil {
+		t.Error("hope error when open the same flag again")
+	}
+	m.SetWithFlag(flag, tbName, key, value2)
+	m.SetWithFlag(flag2, tbName, key, value3)
+	m.SetWithFlag(flag2, tbName, key2, value3)
+	v, _ := m.GetWithFlag(flag, tbName, key)
+	if bytes.Compare(v, value2) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value2, v)
+	}
+	v, _ = m.GetWithFlag(flag2, tbName, key)
+	if bytes.Compare(v, value3) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value3, v)
+	}
+	if ok, _ := m.ExistWithFlag(flag, tbName, key2); ok {
+		t.Error("hope the key of sibling flag not exist")
+	}
+
+	// flag3 is opened on flag, it reads the data of flag
+	err = m.OpenFlagOn(flag, flag3)
+	if err != nil {
+		t.Fatal("fail to open flag on flag.", err)
+	}
+	v, _ = m.GetWithFlag(flag3, tbName, key)
+	if bytes.Compare(v, value2) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value2, v)
+	}
+	m.SetWithFlag(flag3, tbName, key3, value3)
+	items, _, _ := m.ScanWithFlag(flag3, tbName, nil, nil, 0, false)
+	if len(items) != 2 || bytes.Compare(items[1].Key, key3) != 0 {
+		t.Error("error scan of flag3:", items)
+	}
+	if m.Commit(flag3) == nil {
+		t.Error("hope error when the parent flag is not committed")
+	}
+
+	err = m.Commit(flag)
+	if err != nil {
+		t.Fatal("fail to commit.", err)
+	}
+	if _, err = m.GetWithFlag(flag2, tbName, key); err == nil {
+		t.Error("hope the sibling flag is canceled")
+	}
+	if m.Exist(tbName, key2) {
+		t.Error("hope the data of sibling flag is discarded")
+	}
+	v = m.Get(tbName, key)
+	if bytes.Compare(v, value2) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value2, v)
+	}
+
+	// flag3 is on the committed flag now
+	err = m.Commit(flag3)
+	if err != nil {
+		t.Fatal("fail to commit flag3.", err)
+	}
+	if !m.Exist(tbName, key3) || bytes.Compare(m.GetLastFlag(), flag3) != 0 {
+		t.Error("error data after commit flag3")
+	}
+	m.Rollback(flag3)
+	m.Rollback(flag)
+	v = m.Get(tbName, key)
+	if bytes.Compare(v, value) != 0 || m.Exist(tbName, key3) {
+		t.Errorf("different value after rollback,hope:%s,get:%s", value, v)
+	}
+}
+
+func TestCancelFlagOn(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	m.OpenFlag(flag)
+	m.OpenFlagOn(flag, flag2)
+	m.OpenFlag(flag3)
+	m.Cancel(flag)
+	flags := m.GetOpenedFlags()
+	if len(flags) != 1 || bytes.Compare(flags[0], flag3) != 0 {
+		t.Error("hope the flags opened on the canceled flag are canceled:", flags)
+	}
+}
+
+func TestFlagWithChild(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key, value)
+	id, _ := m.Savepoint(flag)
+	m.SetWithFlag(flag, tbName, key, value2)
+	m.OpenFlagOn(flag, flag2)
+	if m.SetWithFlag(flag, tbName, key, value3) == nil || m.DeleteWithFlag(flag, tbName, key) == nil {
+		t.Error("hope error when write the flag which has child flags")
+	}
+	if m.RollbackToSavepoint(flag, id) == nil {
+		t.Error("hope error when rollback the flag which has child flags")
+	}
+	v, _ := m.GetWithFlag(flag2, tbName, key)
+	if bytes.Compare(v, value2) != 0 {
+		t.Errorf("hope the child flag read the data of parent,hope:%s,get:%s", value2, v)
+	}
+
+	// Get/GetLastFlag follow the last opened flag
+	m.OpenFlag(flag3)
+	m.SetWithFlag(flag3, tbName, key, value3)
+	if bytes.Compare(m.GetLastFlag(), flag3) != 0 || bytes.Compare(m.Get(tbName, key), value3) != 0 {
+		t.Errorf("hope read the last opened flag:%x", m.GetLastFlag())
+	}
+	m.Cancel(flag3)
+	if bytes.Compare(m.GetLastFlag(), flag2) != 0 || bytes.Compare(m.Get(tbName, key), value2) != 0 {
+		t.Errorf("hope read the last opened flag after cancel:%x", m.GetLastFlag())
+	}
+
+	m.Cancel(flag2)
+	err = m.SetWithFlag(flag, tbName, key, value3)
+	if err != nil {
+		t.Error("fail to write the flag after the child flag is canceled.", err)
+	}
+}
+
+func TestFlagReuseBuffer(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	m.OpenFlag(flag)
+	buf := []byte("key0")
+	for _, c := range []byte("3142") {
+		buf[3] = c
+		m.SetWithFlag(flag, tbName, buf, buf)
+	}
+	buf[3] = '9'
+	var keys []string
+	for k := m.GetNextKey(tbName, nil); k != nil; k = m.GetNextKey(tbName, k) {
+		keys = append(keys, string(k))
+	}
+	if fmt.Sprint(keys) != "[key1 key2 key3 key4]" {
+		t.Error("error keys after the buffer is reused:", keys)
+	}
+	if v := m.Get(tbName, []byte("key3")); bytes.Compare(v, []byte("key3")) != 0 {
+		t.Errorf("error value after the buffer is reused:%s", v)
+	}
+}
@@ -0,0 +1,393 @@
+package disk
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"time"
+)
+
+// The history of all flags is stored in history.db, the bucket itoa(seq) of a flag
+// has the same buckets as data.db(typ+tbName): ltnFlag/ltnPreValue the data before the flag,
+// ltnValue the data written by the flag, ltnMode the mode of the tables dropped by the flag.
+// The bucket historyIndex: flag -> seq + size of the history.
+const (
+	historyFN    = "history.db"
+	historyIndex = "history_index"
+)
+
+// historyBucket return the bucket of the history of flag, nil if not exist
+func historyBucket(tx Tx, flag []byte) Bucket {
+	v := tx.Bucket([]byte(historyIndex)).Get(flag)
+	if len(v) < 8 {
+		return nil
+	}
+	return tx.Bucket(v[:8])
+}
+
+// historySize return the size of the history of flag
+func historySize(tx Tx, flag []byte) int64 {
+	v := tx.Bucket([]byte(historyIndex)).Get(flag)
+	return int64(atoi(v[8:]))
+}
+
+// checkHistory return error if the history of one of the flags has been removed
+func (m *Manager) checkHistory(flags ...[]byte) error {
+	return m.historyDb.View(func(tx Tx) error {
+		for _, f := range flags {
+			if historyBucket(tx, f) == nil {
+				log.Printf("not found history,flag:%x\n", f)
+				return fmt.Errorf("history removed")
+			}
+		}
+		return nil
+	})
+}
+
+// viewHistory read the history of the flag
+func (m *Manager) viewHistory(flag []byte, fn func(b Bucket) error) error {
+	return m.historyDb.View(func(tx Tx) error {
+		b := historyBucket(tx, flag)
+		if b == nil {
+			log.Printf("not found history,flag:%x\n", flag)
+			return fmt.Errorf("history removed")
+		}
+		return fn(b)
+	})
+}
+
+// removeHistory remove the history of the flags
+func (m *Manager) removeHistory(flags ...[]byte) error {
+	return m.historyDb.Update(func(tx Tx) error {
+		bi := tx.Bucket([]byte(historyIndex))
+		for _, f := range flags {
+			v := bi.Get(f)
+			if len(v) < 8 {
+				continue
+			}
+			if tx.Bucket(v[:8]) != nil {
+				err := tx.DeleteBucket(v[:8])
+				if err != nil {
+					return err
+				}
+			}
+			err := bi.Delete(f)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetAt get the data as of the flag was committed, return nil if the key not exist at that time.
+// It fails if the history of the flag or of the flags after it has been pruned(see Options).
+// Only the changes with flag are recorded in history, the changes of Set/Delete are not.
+func (m *Manager) GetAt(flag, tbName, key []byte) ([]byte, error) {
+	if len(flag) == 0 {
+		return nil, fmt.Errorf("try to get at null flag")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	flags, err := m.flagsAfter(flag)
+	if err != nil {
+		return nil, err
+	}
+	err = m.checkHistory(append(flags, flag)...)
+	if err != nil {
+		return nil, err
+	}
+	// the preValue of the first flag changed the key after flag is the value as of flag
+	for i := len(flags) - 1; i >= 0; i-- {
+		var out []byte
+		var found bool
+		err = m.viewHistory(flags[i], func(hb Bucket) error {
+			b := hb.Bucket(getLocalTableName(ltnPreValue, tbName))
+			if b == nil {
+				return nil
+			}
+			k, v := b.Cursor().Seek(key)
+			if k == nil || bytes.Compare(k, key) != 0 {
+				return nil
+			}
+			found = true
+			if len(v) > 0 {
+				out = dup(v)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return out, nil
+		}
+	}
+	var out []byte
+	m.dataDb.View(func(tx Tx) error {
+		out = getValue(tx, ltnValue, tbName, key)
+		return nil
+	})
+	return out, nil
+}
+
+// Change the change of a key in a flag, empty value means the key not exist
+type Change struct {
+	TbName   []byte
+	Key      []byte
+	PreValue []byte
+	Value    []byte
+}
+
+// GetFlagChanges get the changes of the committed flag, in the order of table and key.
+// Return at most limit changes(limit<=0: no limit) from offset,
+// next is the offset of the next page(0: no more changes).
+func (m *Manager) GetFlagChanges(flag []byte, offset, limit int) ([]Change, int, error) {
+	if len(flag) == 0 {
+		return nil, 0, fmt.Errorf("try to get changes of null flag")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.flagChanges(flag, offset, limit)
+}
+
+func (m *Manager) flagChanges(flag []byte, offset, limit int) ([]Change, int, error) {
+	var out []Change
+	var next int
+	var n int
+	err := m.viewHistory(flag, func(hb Bucket) error {
+		c := hb.Cursor()
+		for name, _ := c.Seek([]byte{ltnValue}); name != nil && name[0] == ltnValue; name, _ = c.Next() {
+			tn := name[1:]
+			b := hb.Bucket(name)
+			bp := hb.Bucket(getLocalTableName(ltnPreValue, tn))
+			c2 := b.Cursor()
+			for k, v := c2.First(); k != nil; k, v = c2.Next() {
+				if n < offset {
+					n++
+					continue
+				}
+				if limit > 0 && len(out) >= limit {
+					next = n
+					return nil
+				}
+				n++
+				it := Change{TbName: dup(tn), Key: dup(k)}
+				if len(v) > 0 {
+					it.Value = dup(v)
+				}
+				if bp != nil {
+					if pv := bp.Get(k); len(pv) > 0 {
+						it.PreValue = dup(pv)
+					}
+				}
+				out = append(out, it)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return out, next, nil
+}
+
+// historyIter iterate the new values of the history of a flag, in the order of table and key
+type historyIter struct {
+	hb Bucket
+	// cursor of the buckets
+	bc Cursor
+	// cursor of the keys in the bucket
+	kc Cursor
+	// start the first key of the iterator, nil: from the first key
+	start  *TableKey
+	tbName []byte
+	key    []byte
+	value  []byte
+}
+
+// newHistoryIter return the iterator from the key of start(included), start=nil: from the first key
+func newHistoryIter(hb Bucket, start *TableKey) *historyIter {
+	it := &historyIter{hb: hb, bc: hb.Cursor(), start: start}
+	var name []byte
+	if start != nil {
+		name, _ = it.bc.Seek(getLocalTableName(ltnValue, start.TbName))
+	} else {
+		name, _ = it.bc.Seek([]byte{ltnValue})
+	}
+	it.nextBucket(name)
+	return it
+}
+
+// nextBucket move to the first key(not before start) of the bucket(name) or the next bucket has key
+func (it *historyIter) nextBucket(name []byte) {
+	for ; name != nil && name[0] == ltnValue; name, _ = it.bc.Next() {
+		it.kc = it.hb.Bucket(name).Cursor()
+		var k, v []byte
+		if it.start != nil && bytes.Compare(name[1:], it.start.TbName) == 0 {
+			k, v = it.kc.Seek(it.start.Key)
+		} else {
+			k, v = it.kc.First()
+		}
+		if k != nil {
+			it.tbName, it.key, it.value = name[1:], k, v
+			return
+		}
+	}
+	it.key = nil
+}
+
+func (it *historyIter) next() {
+	k, v := it.kc.Next()
+	if k != nil {
+		it.key, it.value = k, v
+		return
+	}
+	name, _ := it.bc.Next()
+	it.nextBucket(name)
+}
+
+func (it *historyIter) compare(tbName, key []byte) int {
+	if r := bytes.Compare(it.tbName, tbName); r != 0 {
+		return r
+	}
+	return bytes.Compare(it.key, key)
+}
+
+// ChangesBetween get the net changes of the flags committed after fromFlag, until toFlag(included).
+// If a key is changed by several flags, PreValue is the value before fromFlag(as of fromFlag)
+// and Value is the last value, the keys set back to their original value are skipped.
+// The changes are passed to fn in the order of table and key, stop if fn return error.
+// fn must not call the methods of the manager.
+func (m *Manager) ChangesBetween(fromFlag, toFlag []byte, fn func(Change) error) error {
+	return m.ChangesBetweenFrom(fromFlag, toFlag, nil, fn)
+}
+
+// ChangesBetweenFrom same as ChangesBetween, but start from the key(included) of start, start=nil: from the first key.
+// It is used to get the changes page by page, start is the key of the first change of the next page.
+func (m *Manager) ChangesBetweenFrom(fromFlag, toFlag []byte, start *TableKey, fn func(Change) error) error {
+	if len(fromFlag) == 0 || len(toFlag) == 0 {
+		return fmt.Errorf("try to get changes of null flag")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	flags, err := m.flagsAfter(fromFlag)
+	if err != nil {
+		return err
+	}
+	if bytes.Compare(fromFlag, toFlag) == 0 {
+		return nil
+	}
+	i := 0
+	for ; i < len(flags); i++ {
+		if bytes.Compare(flags[i], toFlag) == 0 {
+			break
+		}
+	}
+	if i == len(flags) {
+		log.Printf("not found the flag:%x after:%x\n", toFlag, fromFlag)
+		return fmt.Errorf("not found flag after fromFlag")
+	}
+	flags = flags[i:]
+	err = m.checkHistory(flags...)
+	if err != nil {
+		return err
+	}
+
+	return m.historyDb.View(func(tx Tx) error {
+		// iterators of the flags, from the first flag after fromFlag
+		var iters []*historyIter
+		for i := len(flags) - 1; i >= 0; i-- {
+			iters = append(iters, newHistoryIter(historyBucket(tx, flags[i]), start))
+		}
+
+		for {
+			// the smallest key of the iterators
+			var first *historyIter
+			for _, it := range iters {
+				if it.key == nil {
+					continue
+				}
+				if first == nil || it.compare(first.tbName, first.key) < 0 {
+					first = it
+				}
+			}
+			if first == nil {
+				return nil
+			}
+			c := Change{TbName: dup(first.tbName), Key: dup(first.key)}
+			if b := first.hb.Bucket(getLocalTableName(ltnPreValue, c.TbName)); b != nil {
+				if v := b.Get(c.Key); len(v) > 0 {
+					c.PreValue = dup(v)
+				}
+			}
+			for _, it := range iters {
+				if it.key == nil || it.compare(c.TbName, c.Key) != 0 {
+					continue
+				}
+				c.Value = nil
+				if len(it.value) > 0 {
+					c.Value = dup(it.value)
+				}
+				it.next()
+			}
+			if bytes.Compare(c.PreValue, c.Value) == 0 {
+				continue
+			}
+			err := fn(c)
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// Prune remove the history out of the retention of the options(HistoryMax, HistoryBytes and HistoryAge),
+// return the flags whose history is removed. It is also called after every Commit.
+// The history of the last flag is always retained.
+func (m *Manager) Prune() ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failed != nil {
+		return nil, m.failed
+	}
+	return m.prune()
+}
+
+func (m *Manager) prune() ([][]byte, error) {
+	var out [][]byte
+	now := time.Now()
+	m.historyDb.View(func(tx Tx) error {
+		return m.flagDb.View(func(ftx Tx) error {
+			c := ftx.Bucket([]byte(flagList)).Cursor()
+			bt := ftx.Bucket([]byte(flagTime))
+			var size int64
+			var i uint64
+			for k, v := c.Last(); k != nil && atoi(k) > 0; k, v = c.Prev() {
+				// the history before the first removed one has been removed
+				if historyBucket(tx, v) == nil {
+					return nil
+				}
+				size += historySize(tx, v)
+				t := int64(atoi(bt.Get(v)))
+				if len(out) > 0 || i > 0 && (i >= m.opt.HistoryMax ||
+					m.opt.HistoryBytes > 0 && size > m.opt.HistoryBytes ||
+					m.opt.HistoryAge > 0 && t > 0 && now.Sub(time.Unix(0, t)) > m.opt.HistoryAge) {
+					out = append(out, dup(v))
+				}
+				i++
+			}
+			return nil
+		})
+	})
+	if len(out) == 0 {
+		return nil, nil
+	}
+	err := m.removeHistory(out...)
+	if err != nil {
+		log.Println("fail to remove history:", err)
+		return nil, err
+	}
+	log.Printf("prune history,number:%d,from:%x\n", len(out), out[0])
+	return out, nil
+}
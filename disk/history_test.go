@@ -0,0 +1,262 @@
+package disk
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGetAt(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key, value)
+	m.Commit(flag)
+	m.OpenFlag(flag2)
+	m.SetWithFlag(flag2, tbName, key, value2)
+	m.SetWithFlag(flag2, tbName, key2, value2)
+	m.Commit(flag2)
+	m.OpenFlag(flag3)
+	m.DeleteWithFlag(flag3, tbName, key)
+	m.Commit(flag3)
+
+	hope := map[string][][]byte{
+		string(flag):  {value, nil},
+		string(flag2): {value2, value2},
+		string(flag3): {nil, value2},
+	}
+	for f, values := range hope {
+		for i, k := range [][]byte{key, key2} {
+			v, err := m.GetAt([]byte(f), tbName, k)
+			if err != nil {
+				t.Fatal("fail to get at flag:", f, err)
+			}
+			if bytes.Compare(v, values[i]) != 0 {
+				t.Errorf("different value,flag:%s,key:%s,hope:%s,get:%s", f, k, values[i], v)
+			}
+		}
+	}
+	_, err = m.GetAt([]byte("not exist"), tbName, key)
+	if err == nil {
+		t.Error("hope error of the flag not committed")
+	}
+}
+
+func TestGetAtPruned(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, &Options{HistoryMax: 1})
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key, value)
+	m.Commit(flag)
+	m.OpenFlag(flag2)
+	m.SetWithFlag(flag2, tbName, key, value2)
+	m.Commit(flag2)
+	_, err = m.GetAt(flag, tbName, key)
+	if err == nil {
+		t.Error("hope error of the pruned flag")
+	}
+	v, err := m.GetAt(flag2, tbName, key)
+	if err != nil || bytes.Compare(v, value2) != 0 {
+		t.Errorf("different value,hope:%s,get:%s,%v", value2, v, err)
+	}
+}
+
+func TestGetFlagChanges(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	tbName2 := []byte("tbName2")
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key, value)
+	m.SetWithFlag(flag, tbName, key2, value)
+	m.Commit(flag)
+	m.OpenFlag(flag2)
+	m.SetWithFlag(flag2, tbName, key, value2)
+	m.DeleteWithFlag(flag2, tbName, key2)
+	m.SetWithFlag(flag2, tbName2, key3, value3)
+	m.Commit(flag2)
+
+	hope := []Change{
+		{tbName, key, value, value2},
+		{tbName, key2, value, nil},
+		{tbName2, key3, nil, value3},
+	}
+	var changes []Change
+	var offset int
+	for {
+		items, next, err := m.GetFlagChanges(flag2, offset, 2)
+		if err != nil {
+			t.Fatal("fail to get changes.", err)
+		}
+		changes = append(changes, items...)
+		if next == 0 {
+			break
+		}
+		offset = next
+	}
+	if len(changes) != len(hope) {
+		t.Fatal("different number of changes:", len(changes))
+	}
+	for i, it := range changes {
+		h := hope[i]
+		if bytes.Compare(it.TbName, h.TbName) != 0 || bytes.Compare(it.Key, h.Key) != 0 ||
+			bytes.Compare(it.PreValue, h.PreValue) != 0 || bytes.Compare(it.Value, h.Value) != 0 {
+			t.Errorf("different change,index:%d,hope:%s,get:%s", i, h, it)
+		}
+	}
+	_, _, err = m.GetFlagChanges(flag3, 0, 0)
+	if err == nil {
+		t.Error("hope error of the flag not committed")
+	}
+}
+
+func TestChangesBetween(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	key4 := []byte("key4")
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key, value)
+	m.SetWithFlag(flag, tbName, key2, value)
+	m.Commit(flag)
+	m.OpenFlag(flag2)
+	m.SetWithFlag(flag2, tbName, key, value2)
+	m.SetWithFlag(flag2, tbName, key2, value2)
+	m.SetWithFlag(flag2, tbName, key3, value2)
+	m.Commit(flag2)
+	m.OpenFlag(flag3)
+	m.SetWithFlag(flag3, tbName, key, value3)
+	// set back to the original value
+	m.SetWithFlag(flag3, tbName, key2, value)
+	m.DeleteWithFlag(flag3, tbName, key3)
+	m.SetWithFlag(flag3, tbName, key4, value3)
+	m.Commit(flag3)
+
+	hope := []Change{
+		{tbName, key, value, value3},
+		{tbName, key4, nil, value3},
+	}
+	var changes []Change
+	err = m.ChangesBetween(flag, flag3, func(c Change) error {
+		changes = append(changes, c)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("fail to get changes.", err)
+	}
+	if len(changes) != len(hope) {
+		t.Fatal("different number of changes:", changes)
+	}
+	for i, it := range changes {
+		h := hope[i]
+		if bytes.Compare(it.TbName, h.TbName) != 0 || bytes.Compare(it.Key, h.Key) != 0 ||
+			bytes.Compare(it.PreValue, h.PreValue) != 0 || bytes.Compare(it.Value, h.Value) != 0 {
+			t.Errorf("different change,index:%d,hope:%s,get:%s", i, h, it)
+		}
+	}
+
+	// get the changes from the key
+	for _, start := range []TableKey{{tbName, key2}, {tbName, key4}} {
+		changes = nil
+		m.ChangesBetweenFrom(flag, flag3, &start, func(c Change) error {
+			changes = append(changes, c)
+			return nil
+		})
+		if len(changes) != 1 || bytes.Compare(changes[0].Key, key4) != 0 {
+			t.Errorf("error changes from %s:%s", start.Key, changes)
+		}
+	}
+	changes = nil
+	m.ChangesBetweenFrom(flag, flag3, &TableKey{tbName, []byte("key5")}, func(c Change) error {
+		changes = append(changes, c)
+		return nil
+	})
+	if len(changes) != 0 {
+		t.Error("hope no change after the last key:", changes)
+	}
+
+	changes = nil
+	m.ChangesBetween(flag, flag2, func(c Change) error {
+		changes = append(changes, c)
+		return nil
+	})
+	if len(changes) != 3 {
+		t.Error("different number of changes:", changes)
+	}
+	err = m.ChangesBetween(flag3, flag, func(c Change) error { return nil })
+	if err == nil {
+		t.Error("hope error when toFlag is before fromFlag")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	flags := [][]byte{flag, flag2, flag3}
+	for _, f := range flags {
+		m.OpenFlag(f)
+		m.SetWithFlag(f, tbName, key, f)
+		m.Commit(f)
+	}
+	out, err := m.Prune()
+	if err != nil || len(out) != 0 {
+		t.Fatal("hope nothing pruned:", out, err)
+	}
+	m.Close()
+
+	// retention by bytes, retain the last flag at least
+	m, err = Open(testDir, &Options{HistoryBytes: 1})
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	if m.checkHistory(flag3) != nil || m.checkHistory(flag2) == nil || m.checkHistory(flag) == nil {
+		t.Error("hope only the history of the last flag is retained")
+	}
+	m.Close()
+
+	m, err = Open(testDir, &Options{HistoryAge: time.Hour})
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	m.OpenFlag(key)
+	m.SetWithFlag(key, tbName, key, value)
+	m.Commit(key)
+	m.opt.HistoryAge = time.Nanosecond
+	out, err = m.Prune()
+	if err != nil || len(out) != 1 || bytes.Compare(out[0], flag3) != 0 {
+		t.Error("hope the history of flag3 is pruned by age:", out, err)
+	}
+	if m.Rollback(key) != nil {
+		t.Error("fail to rollback the last flag")
+	}
+}
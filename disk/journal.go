@@ -0,0 +1,359 @@
+package disk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+)
+
+// journal the commit journal(write-ahead log).
+// Commit writes the journal before changing any file, then applies it to flag.db, history.db and data.db.
+// If the process crashes, Open replays a complete journal and discards an incomplete one,
+// so a commit is either fully applied or fully discarded.
+type journal struct {
+	Flag []byte
+	Seq  uint64
+	// Time the time of commit, unix nano
+	Time  int64
+	Items []journalItem
+	// Dropped the tables dropped by the flag, see DropTableWithFlag
+	Dropped []journalDrop
+}
+
+// journalDrop the table dropped by the flag, PreMode is the mode kept in data.db before the commit
+type journalDrop struct {
+	TbName  []byte
+	PreMode TableMode
+}
+
+type journalItem struct {
+	TbName   []byte
+	Key      []byte
+	Value    []byte
+	PreFlag  []byte
+	PreValue []byte
+	WithFlag bool
+}
+
+const journalFN = "commit.wal"
+
+// the steps of commit and rollback, commitHook is called after each step
+const (
+	stepJournalWrite = "journal_write"
+	stepJournalSync  = "journal_sync"
+	stepFlagList     = "flag_list"
+	stepHistory      = "history"
+	stepData         = "data"
+	stepLastFlag     = "last_flag"
+	// stepRollbackFlag the target flag of rollback is recorded as the committed flag
+	stepRollbackFlag = "rollback_flag"
+	// stepRollbackData the data of the flags are restored
+	stepRollbackData = "rollback_data"
+)
+
+// commitHook is only used by test to simulate crash or failure(return error) at the step
+var commitHook = func(step string) error { return nil }
+
+func (m *Manager) getJournalFileName() string {
+	return path.Join(m.dir, journalFN)
+}
+
+// writeJournal write the journal to file and sync it.
+// format: length(8 bytes) + gob data + crc32 of data(4 bytes)
+func (m *Manager) writeJournal(j *journal) error {
+	if m.inMemory() {
+		return nil
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(j)
+	if err != nil {
+		log.Println("fail to encode journal:", err)
+		return err
+	}
+	data := buf.Bytes()
+	f, err := os.OpenFile(m.getJournalFileName(), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fileMode)
+	if err != nil {
+		log.Println("fail to create journal:", err)
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(itoa(uint64(len(data))), data...))
+	if err != nil {
+		log.Println("fail to write journal:", err)
+		return err
+	}
+	err = commitHook(stepJournalWrite)
+	if err != nil {
+		return err
+	}
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(data))
+	_, err = f.Write(crc)
+	if err != nil {
+		log.Println("fail to write journal:", err)
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		log.Println("fail to sync journal:", err)
+		return err
+	}
+	syncDir(m.dir)
+	return commitHook(stepJournalSync)
+}
+
+// readJournal read the journal, return nil if not exist or incomplete
+func (m *Manager) readJournal() *journal {
+	data, err := ioutil.ReadFile(m.getJournalFileName())
+	if err != nil {
+		return nil
+	}
+	if len(data) < 12 {
+		log.Println("incomplete journal,length:", len(data))
+		return nil
+	}
+	l := atoi(data[:8])
+	if l != uint64(len(data)-12) {
+		log.Println("incomplete journal,length:", len(data), l)
+		return nil
+	}
+	data = data[8:]
+	crc := binary.BigEndian.Uint32(data[l:])
+	if crc != crc32.ChecksumIEEE(data[:l]) {
+		log.Println("incomplete journal,different crc")
+		return nil
+	}
+	j := new(journal)
+	err = gob.NewDecoder(bytes.NewReader(data[:l])).Decode(j)
+	if err != nil {
+		log.Println("fail to decode journal:", err)
+		return nil
+	}
+	return j
+}
+
+func (m *Manager) removeJournal() {
+	if m.inMemory() {
+		return
+	}
+	os.Remove(m.getJournalFileName())
+}
+
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// applyJournal write the journal to flag.db, history.db and data.db.
+// every step can be applied again, so the journal is replayed after crash.
+func (m *Manager) applyJournal(j *journal) error {
+	// set last flag
+	err := m.flagDb.Update(func(tx Tx) error {
+		b2 := tx.Bucket([]byte(flagList))
+		return b2.Put(itoa(j.Seq), j.Flag)
+	})
+	if err != nil {
+		log.Println("fail to set lastFlag.", err)
+		return err
+	}
+	err = commitHook(stepFlagList)
+	if err != nil {
+		return err
+	}
+
+	// write data to file for rollback
+	err = m.writeHistory(j)
+	if err != nil {
+		return err
+	}
+	err = commitHook(stepHistory)
+	if err != nil {
+		return err
+	}
+
+	// write data to data.db
+	tx2, err := m.dataDb.Begin(true)
+	if err != nil {
+		log.Println("fail to begin flag file:", err)
+		return err
+	}
+	defer tx2.Rollback()
+	for _, it := range j.Items {
+		if !it.WithFlag {
+			continue
+		}
+		b, err := tx2.CreateBucketIfNotExists(getLocalTableName(ltnFlag, it.TbName))
+		if err != nil {
+			log.Println("fail to create bucket(history flag):", it.TbName, err)
+			return err
+		}
+		err = b.Put(it.Key, j.Flag)
+		if err != nil {
+			log.Println("fail to put bucket(flag):", it.TbName, it.Key, err)
+			return err
+		}
+		err = smtSet(tx2, it.TbName, it.Key, it.Value)
+		if err != nil {
+			log.Println("fail to update smt:", it.TbName, it.Key, err)
+			return err
+		}
+	}
+	for _, it := range j.Items {
+		b, err := tx2.CreateBucketIfNotExists(getLocalTableName(ltnValue, it.TbName))
+		if err != nil {
+			log.Println("fail to create bucket(history value):", it.TbName, err)
+			return err
+		}
+		err = putOrDelete(b, it.Key, it.Value)
+		if err != nil {
+			log.Println("fail to put bucket(value):", it.TbName, it.Key, err)
+			return err
+		}
+	}
+	for _, d := range j.Dropped {
+		err = dropEmptyTable(tx2, d.TbName)
+		if err != nil {
+			log.Println("fail to drop table:", d.TbName, err)
+			return err
+		}
+	}
+	err = tx2.Commit()
+	if err != nil {
+		log.Println("fail to commit data.", err)
+		return err
+	}
+	err = commitHook(stepData)
+	if err != nil {
+		return err
+	}
+
+	var root []byte
+	m.dataDb.View(func(tx Tx) error {
+		root = smtRoot(tx)
+		return nil
+	})
+	err = m.flagDb.Update(func(tx Tx) error {
+		err := tx.Bucket([]byte(flagRoot)).Put(j.Flag, root)
+		if err != nil {
+			return err
+		}
+		hash := commitHash(getPreHash(tx, j.Seq), j.Flag, journalChanges(j))
+		err = tx.Bucket([]byte(flagHash)).Put(j.Flag, hash)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket([]byte(flagTime)).Put(j.Flag, itoa(uint64(j.Time)))
+		if err != nil {
+			return err
+		}
+		b2 := tx.Bucket([]byte(flagList))
+		return b2.Put(itoa(0), j.Flag)
+	})
+	if err != nil {
+		log.Println("fail to update lastFlag.", err)
+		return err
+	}
+	return commitHook(stepLastFlag)
+}
+
+// writeHistory write the history of the flag to history.db, the old history is replaced
+func (m *Manager) writeHistory(j *journal) error {
+	return m.historyDb.Update(func(tx Tx) error {
+		name := itoa(j.Seq)
+		if tx.Bucket(name) != nil {
+			err := tx.DeleteBucket(name)
+			if err != nil {
+				return err
+			}
+		}
+		hb, err := tx.CreateBucket(name)
+		if err != nil {
+			log.Println("fail to create bucket(history):", j.Seq, err)
+			return err
+		}
+		var size int
+		for _, it := range j.Items {
+			if !it.WithFlag {
+				continue
+			}
+			size += len(it.TbName) + len(it.Key) + len(it.PreFlag) + len(it.PreValue) + len(it.Value)
+			b1, err := hb.CreateBucketIfNotExists(getLocalTableName(ltnFlag, it.TbName))
+			if err != nil {
+				log.Println("fail to create bucket(history flag):", it.TbName, err)
+				return err
+			}
+			err = b1.Put(it.Key, it.PreFlag)
+			if err != nil {
+				log.Println("fail to put bucket(flag):", it.TbName, it.Key, err)
+				return err
+			}
+			b2, err := hb.CreateBucketIfNotExists(getLocalTableName(ltnPreValue, it.TbName))
+			if err != nil {
+				log.Println("fail to create bucket(history preValue):", it.TbName, err)
+				return err
+			}
+			err = b2.Put(it.Key, it.PreValue)
+			if err != nil {
+				log.Println("fail to put bucket(preValue):", it.TbName, it.Key, err)
+				return err
+			}
+			b3, err := hb.CreateBucketIfNotExists(getLocalTableName(ltnValue, it.TbName))
+			if err != nil {
+				log.Println("fail to create bucket(history value):", it.TbName, err)
+				return err
+			}
+			err = b3.Put(it.Key, it.Value)
+			if err != nil {
+				log.Println("fail to put bucket(value):", it.TbName, it.Key, err)
+				return err
+			}
+		}
+		if len(j.Dropped) > 0 {
+			b, err := hb.CreateBucketIfNotExists([]byte{ltnMode})
+			if err != nil {
+				log.Println("fail to create bucket(history mode):", err)
+				return err
+			}
+			for _, d := range j.Dropped {
+				err = b.Put(getLocalTableName(ltnValue, d.TbName), []byte{byte(d.PreMode)})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Bucket([]byte(historyIndex)).Put(j.Flag, append(name, itoa(uint64(size))...))
+	})
+}
+
+// replayJournal apply the journal left by a crashed commit
+func (m *Manager) replayJournal(r *RecoveryReport) error {
+	if _, err := os.Stat(m.getJournalFileName()); os.IsNotExist(err) {
+		return nil
+	}
+	j := m.readJournal()
+	if j == nil {
+		log.Println("discard incomplete journal:", m.dir)
+		m.removeJournal()
+		r.Journal = JournalDiscarded
+		return nil
+	}
+	log.Printf("replay journal,flag:%x,seq:%d\n", j.Flag, j.Seq)
+	err := m.applyJournal(j)
+	if err != nil {
+		return fmt.Errorf("fail to replay journal:%s", err)
+	}
+	m.removeJournal()
+	r.Journal = JournalReplayed
+	r.JournalFlag = j.Flag
+	return nil
+}
@@ -0,0 +1,175 @@
+package disk
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+var (
+	key2 = []byte("key2")
+	key3 = []byte("key3")
+)
+
+// TestCommitCrashHelper is run in a child process by TestCommitCrash,
+// the process exits with code 3 at the step of commit(env CRASH_STEP).
+func TestCommitCrashHelper(t *testing.T) {
+	dir := os.Getenv("CRASH_DIR")
+	step := os.Getenv("CRASH_STEP")
+	if dir == "" || step == "" {
+		t.Skip("only run by TestCommitCrash")
+	}
+	m, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key, value)
+	m.SetWithFlag(flag, tbName, key2, value)
+	err = m.Commit(flag)
+	if err != nil {
+		t.Fatal("fail to commit", err)
+	}
+
+	commitHook = func(s string) error {
+		if s == step {
+			os.Exit(3)
+		}
+		return nil
+	}
+	m.OpenFlag(flag2)
+	m.SetWithFlag(flag2, tbName, key, value2)
+	m.DeleteWithFlag(flag2, tbName, key2)
+	m.SetWithFlag(flag2, tbName, key3, value3)
+	m.Commit(flag2)
+	t.Fatal("not crash at step:", step)
+}
+
+func TestCommitCrash(t *testing.T) {
+	log.Println("start test:", t.Name())
+	dir := testDir + "_crash"
+	defer os.RemoveAll(dir)
+	steps := []string{stepJournalWrite, stepJournalSync, stepFlagList,
+		stepHistory, stepData, stepLastFlag}
+	for _, step := range steps {
+		os.RemoveAll(dir)
+		cmd := exec.Command(os.Args[0], "-test.run=^TestCommitCrashHelper$")
+		cmd.Env = append(os.Environ(), "CRASH_DIR="+dir, "CRASH_STEP="+step)
+		err := cmd.Run()
+		if e, ok := err.(*exec.ExitError); !ok || e.ExitCode() != 3 {
+			t.Fatal("the child process did not crash:", step, err)
+		}
+
+		m, err := Open(dir, nil)
+		if err != nil {
+			t.Fatal("fail to open dir after crash:", step, err)
+		}
+		if _, err := os.Stat(m.getJournalFileName()); !os.IsNotExist(err) {
+			t.Error("the journal is not removed:", step)
+		}
+		r := m.RecoveryReport()
+		if step == stepJournalWrite && r.Journal != JournalDiscarded ||
+			step != stepJournalWrite && r.Journal != JournalReplayed {
+			t.Error("error journal state of report:", step, r.Journal)
+		}
+		// the journal is incomplete before it is synced, so the commit is discarded
+		if step == stepJournalWrite {
+			lf := m.GetLastFlag()
+			v := m.Get(tbName, key)
+			if bytes.Compare(lf, flag) != 0 || bytes.Compare(v, value) != 0 ||
+				!m.Exist(tbName, key2) || m.Exist(tbName, key3) {
+				t.Errorf("hope the commit is discarded,step:%s,last flag:%s,value:%s", step, lf, v)
+			}
+			m.Close()
+			continue
+		}
+		lf := m.GetLastFlag()
+		v := m.Get(tbName, key)
+		if bytes.Compare(lf, flag2) != 0 || bytes.Compare(v, value2) != 0 ||
+			m.Exist(tbName, key2) || !m.Exist(tbName, key3) {
+			t.Errorf("hope the commit is applied,step:%s,last flag:%s,value:%s", step, lf, v)
+		}
+		err = m.Rollback(flag2)
+		if err != nil {
+			t.Error("fail to rollback:", step, err)
+		}
+		v = m.Get(tbName, key)
+		if bytes.Compare(v, value) != 0 || !m.Exist(tbName, key2) || m.Exist(tbName, key3) {
+			t.Errorf("error value after rollback,step:%s,value:%s", step, v)
+		}
+		m.Close()
+	}
+}
+
+func TestCommitFail(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	defer func() { commitHook = func(string) error { return nil } }()
+	steps := []string{stepJournalWrite, stepJournalSync, stepFlagList,
+		stepHistory, stepData, stepLastFlag}
+	for _, step := range steps {
+		os.RemoveAll(testDir)
+		m, err := Open(testDir, nil)
+		if err != nil {
+			t.Fatal("fail to open dir", err)
+		}
+		m.OpenFlag(flag)
+		m.SetWithFlag(flag, tbName, key, value)
+		m.Commit(flag)
+
+		commitHook = func(s string) error {
+			if s == step {
+				return fmt.Errorf("failure at %s", s)
+			}
+			return nil
+		}
+		m.OpenFlag(flag2)
+		m.SetWithFlag(flag2, tbName, key, value2)
+		m.SetWithFlag(flag2, tbName, key2, value2)
+		if m.Commit(flag2) == nil {
+			t.Fatal("hope error when commit fails:", step)
+		}
+		commitHook = func(string) error { return nil }
+
+		// nothing is changed if fail to write journal
+		if step == stepJournalWrite || step == stepJournalSync {
+			err = m.Commit(flag2)
+			if err != nil {
+				t.Error("fail to commit again:", step, err)
+			}
+			m.Close()
+			continue
+		}
+		if m.Commit(flag2) == nil {
+			t.Error("hope error when commit after the failed commit:", step)
+		}
+		if m.Set([]byte("tbName2"), key, value) == nil || m.OpenFlag(flag3) == nil || m.Rollback(flag) == nil {
+			t.Error("hope error when write after the failed commit:", step)
+		}
+		m.Close()
+
+		m, err = Open(testDir, nil)
+		if err != nil {
+			t.Fatal("fail to open dir after the failed commit:", step, err)
+		}
+		if r := m.RecoveryReport(); r.Journal != JournalReplayed {
+			t.Error("hope the journal is replayed:", step, r.Journal)
+		}
+		lf := m.GetLastFlag()
+		v := m.Get(tbName, key)
+		if bytes.Compare(lf, flag2) != 0 || bytes.Compare(v, value2) != 0 || !m.Exist(tbName, key2) {
+			t.Errorf("hope the commit is applied,step:%s,last flag:%s,value:%s", step, lf, v)
+		}
+		err = m.Rollback(flag2)
+		if err != nil {
+			t.Error("fail to rollback:", step, err)
+		}
+		if v = m.Get(tbName, key); bytes.Compare(v, value) != 0 || m.Exist(tbName, key2) {
+			t.Errorf("error value after rollback,step:%s,value:%s", step, v)
+		}
+		m.Close()
+	}
+}
@@ -0,0 +1,596 @@
+package disk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The log engine keeps the index of all keys in memory(memEngine), the values are appended to the segment files.
+// Every writable transaction is appended to the active segment as one batch:
+// length(4 bytes) + crc32(4 bytes) + ops, op: type + path of bucket + key + value(put only).
+// A broken batch at the end of the last segment(crash when writing) is truncated when open.
+// The compaction writes all live data to a snapshot segment(.snap) and removes the segments before it.
+const (
+	logSegExt     = ".log"
+	logSnapExt    = ".snap"
+	logTmpExt     = ".tmp"
+	logHeaderSize = 8
+	logBatchSize  = 1 << 20
+)
+
+// the type of op in the log
+const (
+	logPut byte = iota + 1
+	logDelete
+	logCreateBucket
+	logDeleteBucket
+)
+
+var (
+	// logSegmentSize the active segment is changed to a new one when it is larger than it
+	logSegmentSize int64 = 64 << 20
+	// logCompactSize compact the log when the useless bytes are larger than it and half of the log
+	logCompactSize int64 = 64 << 20
+	errLogCorrupt        = fmt.Errorf("log corrupt")
+)
+
+type logStore struct {
+	dir string
+	e   *memEngine
+	// the segments in the order of id, the last is the active one
+	segs   []*logSegment
+	nextID uint64
+	// the bytes of the log made useless by the later changes
+	dead      int64
+	compactMu sync.Mutex
+	compactCh chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+type logSegment struct {
+	id   uint64
+	fn   string
+	f    *os.File
+	size int64
+}
+
+// logOp the change of the transaction, item is nil if delete
+type logOp struct {
+	op   byte
+	path [][]byte
+	key  []byte
+	item *memItem
+}
+
+// logEntry the live data of the snapshot, the location of the value is the one when snapshot
+type logEntry struct {
+	op   byte
+	path [][]byte
+	key  []byte
+	item *memItem
+	seg  *logSegment
+	off  int64
+	size int
+}
+
+func openLog(dir string) (Engine, error) {
+	_, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		err = os.Mkdir(dir, 0777)
+		if err != nil {
+			return nil, err
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	e := newMemEngine()
+	s := &logStore{dir: dir, e: e, nextID: 1, compactCh: make(chan struct{}, 1), done: make(chan struct{})}
+	e.log = s
+
+	type segFile struct {
+		id  uint64
+		ext string
+	}
+	var list []segFile
+	var snap uint64
+	for _, f := range files {
+		name := f.Name()
+		ext := path.Ext(name)
+		if ext == logTmpExt {
+			// the snapshot not finished
+			os.Remove(path.Join(dir, name))
+			continue
+		}
+		if ext != logSegExt && ext != logSnapExt {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 16, 64)
+		if err != nil {
+			continue
+		}
+		if ext == logSnapExt && id > snap {
+			snap = id
+		}
+		list = append(list, segFile{id, ext})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].id < list[j].id
+	})
+	for i, sf := range list {
+		if sf.id >= s.nextID {
+			s.nextID = sf.id + 1
+		}
+		if sf.id < snap {
+			// the compaction was interrupted before removing the old segments
+			os.Remove(path.Join(dir, segmentName(sf.id, sf.ext)))
+			continue
+		}
+		seg, err := s.openSegment(sf.id, sf.ext)
+		if err != nil {
+			s.closeFiles()
+			return nil, err
+		}
+		s.segs = append(s.segs, seg)
+		end, err := s.replay(seg)
+		if err == nil {
+			continue
+		}
+		if i < len(list)-1 || sf.ext == logSnapExt {
+			log.Printf("fail to replay log:%s,offset:%d,%v\n", seg.fn, end, err)
+			s.closeFiles()
+			return nil, err
+		}
+		log.Printf("truncate the broken tail of log:%s,offset:%d,%v\n", seg.fn, end, err)
+		err = seg.f.Truncate(end)
+		if err != nil {
+			s.closeFiles()
+			return nil, err
+		}
+		seg.size = end
+	}
+	if len(s.segs) == 0 || path.Ext(s.segs[len(s.segs)-1].fn) != logSegExt {
+		err = s.rotate()
+		if err != nil {
+			s.closeFiles()
+			return nil, err
+		}
+	}
+	s.wg.Add(1)
+	go s.run()
+	s.notifyCompact()
+	return e, nil
+}
+
+func segmentName(id uint64, ext string) string {
+	return fmt.Sprintf("%016x%s", id, ext)
+}
+
+func (s *logStore) openSegment(id uint64, ext string) (*logSegment, error) {
+	fn := path.Join(s.dir, segmentName(id, ext))
+	f, err := os.OpenFile(fn, os.O_CREATE|os.O_RDWR, fileMode)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &logSegment{id: id, fn: fn, f: f, size: info.Size()}, nil
+}
+
+// rotate create a new active segment
+func (s *logStore) rotate() error {
+	seg, err := s.openSegment(s.nextID, logSegExt)
+	if err != nil {
+		return err
+	}
+	s.nextID++
+	s.segs = append(s.segs, seg)
+	syncDir(s.dir)
+	return nil
+}
+
+func (s *logStore) closeFiles() {
+	for _, seg := range s.segs {
+		seg.f.Close()
+	}
+	s.segs = nil
+}
+
+func (s *logStore) close() error {
+	close(s.done)
+	s.wg.Wait()
+	s.e.mu.Lock()
+	defer s.e.mu.Unlock()
+	s.closeFiles()
+	return nil
+}
+
+func (seg *logSegment) readAt(off int64, size int) ([]byte, error) {
+	out := make([]byte, size)
+	_, err := seg.f.ReadAt(out, off)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (seg *logSegment) read(off int64, size int) []byte {
+	out, err := seg.readAt(off, size)
+	if err != nil {
+		log.Printf("fail to read log:%s,offset:%d,%v\n", seg.fn, off, err)
+		return nil
+	}
+	return out
+}
+
+// logOpSize return the bytes of the op in the log(and the header of batch), except the value
+func logOpSize(path [][]byte, key []byte) int64 {
+	out := int64(len(key)+4) + logHeaderSize
+	for _, p := range path {
+		out += int64(len(p) + 1)
+	}
+	return out
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendBytes(buf, data []byte) []byte {
+	buf = appendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+// appendOp append the op to the batch, return the position of the value in the batch
+func appendOp(buf []byte, op byte, path [][]byte, key, value []byte) ([]byte, int) {
+	buf = append(buf, op)
+	buf = appendUvarint(buf, uint64(len(path)))
+	for _, p := range path {
+		buf = appendBytes(buf, p)
+	}
+	buf = appendBytes(buf, key)
+	if op != logPut {
+		return buf, 0
+	}
+	buf = appendUvarint(buf, uint64(len(value)))
+	pos := len(buf)
+	return append(buf, value...), pos
+}
+
+func newBatch() []byte {
+	return make([]byte, logHeaderSize, 4096)
+}
+
+func sealBatch(buf []byte) {
+	binary.BigEndian.PutUint32(buf, uint32(len(buf)-logHeaderSize))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(buf[logHeaderSize:]))
+}
+
+// logReader decode the ops of a batch
+type logReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *logReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		r.err = errLogCorrupt
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *logReader) bytes() []byte {
+	n := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+	if uint64(len(r.data)-r.pos) < n {
+		r.err = errLogCorrupt
+		return nil
+	}
+	out := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return out
+}
+
+// replay apply the batches of the segment to the index, return the end of the valid batches
+func (s *logStore) replay(seg *logSegment) (int64, error) {
+	r := bufio.NewReader(io.NewSectionReader(seg.f, 0, seg.size))
+	header := make([]byte, logHeaderSize)
+	var off int64
+	for {
+		_, err := io.ReadFull(r, header)
+		if err == io.EOF {
+			return off, nil
+		}
+		if err != nil {
+			return off, err
+		}
+		n := int64(binary.BigEndian.Uint32(header))
+		if n == 0 || n > seg.size-off-logHeaderSize {
+			return off, errLogCorrupt
+		}
+		data := make([]byte, n)
+		_, err = io.ReadFull(r, data)
+		if err != nil {
+			return off, err
+		}
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+			return off, errLogCorrupt
+		}
+		ops, err := decodeBatch(seg, off+logHeaderSize, data)
+		if err != nil {
+			return off, err
+		}
+		for _, op := range ops {
+			err = s.apply(op)
+			if err != nil {
+				return off, err
+			}
+		}
+		off += logHeaderSize + n
+	}
+}
+
+// decodeBatch decode the ops of the batch at off of the segment
+func decodeBatch(seg *logSegment, off int64, data []byte) ([]logOp, error) {
+	var out []logOp
+	r := &logReader{data: data}
+	for r.pos < len(data) && r.err == nil {
+		op := logOp{op: data[r.pos]}
+		r.pos++
+		n := r.uvarint()
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			op.path = append(op.path, dup(r.bytes()))
+		}
+		op.key = dup(r.bytes())
+		if op.op == logPut {
+			value := r.bytes()
+			op.item = &memItem{seg: seg, off: off + int64(r.pos-len(value)), size: len(value)}
+		}
+		out = append(out, op)
+	}
+	return out, r.err
+}
+
+// apply apply the op to the index
+func (s *logStore) apply(op logOp) error {
+	b := s.e.root
+	for _, name := range op.path {
+		v, ok := b.items.Get(name)
+		if !ok || v.(*memItem).bucket == nil {
+			return fmt.Errorf("not found bucket:%x", name)
+		}
+		b = v.(*memItem).bucket
+	}
+	var old interface{}
+	var ok bool
+	switch op.op {
+	case logPut:
+		old, ok = b.items.Put(op.key, op.item)
+	case logCreateBucket:
+		if v, exist := b.items.Get(op.key); exist && v.(*memItem).bucket != nil {
+			return nil
+		}
+		old, ok = b.items.Put(op.key, &memItem{bucket: newMemBucket()})
+	case logDelete, logDeleteBucket:
+		old, ok = b.items.Delete(op.key)
+		s.dead += logOpSize(op.path, op.key)
+	default:
+		return errLogCorrupt
+	}
+	if ok {
+		s.dead += old.(*memItem).logSize(op.path, op.key)
+	}
+	return nil
+}
+
+// write append the changes of the transaction to the active segment, it is called by Commit
+func (s *logStore) write(t *memTx) error {
+	type value struct {
+		item *memItem
+		pos  int
+	}
+	var values []value
+	buf := newBatch()
+	for _, op := range t.ops {
+		var pos int
+		if op.op == logPut {
+			buf, pos = appendOp(buf, op.op, op.path, op.key, op.item.value)
+			values = append(values, value{op.item, pos})
+		} else {
+			buf, _ = appendOp(buf, op.op, op.path, op.key, nil)
+		}
+	}
+	sealBatch(buf)
+	seg := s.segs[len(s.segs)-1]
+	_, err := seg.f.WriteAt(buf, seg.size)
+	if err == nil {
+		err = seg.f.Sync()
+	}
+	if err != nil {
+		log.Printf("fail to write log:%s,%v\n", seg.fn, err)
+		seg.f.Truncate(seg.size)
+		return err
+	}
+	for _, v := range values {
+		v.item.size = len(v.item.value)
+		v.item.off = seg.size + int64(v.pos)
+		v.item.seg = seg
+		v.item.value = nil
+	}
+	seg.size += int64(len(buf))
+	s.dead += t.dead
+	if seg.size >= logSegmentSize {
+		err = s.rotate()
+		if err != nil {
+			log.Printf("fail to create log segment:%s,%v\n", s.dir, err)
+		}
+	}
+	s.notifyCompact()
+	return nil
+}
+
+func (s *logStore) notifyCompact() {
+	var total int64
+	for _, seg := range s.segs {
+		total += seg.size
+	}
+	if s.dead < logCompactSize || s.dead*2 < total {
+		return
+	}
+	select {
+	case s.compactCh <- struct{}{}:
+	default:
+	}
+}
+
+func (s *logStore) run() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.compactCh:
+			err := s.compact()
+			if err != nil {
+				log.Printf("fail to compact log:%s,%v\n", s.dir, err)
+			}
+		}
+	}
+}
+
+// walkEntries return the buckets and keys of the bucket
+func walkEntries(b *memBucket, path [][]byte, out []logEntry) []logEntry {
+	for n := b.items.First(); n != nil; n = n.next[0] {
+		it := n.value.(*memItem)
+		if it.bucket == nil {
+			out = append(out, logEntry{logPut, path, n.key, it, it.seg, it.off, it.size})
+			continue
+		}
+		out = append(out, logEntry{op: logCreateBucket, path: path, key: n.key})
+		sub := make([][]byte, len(path)+1)
+		copy(sub, path)
+		sub[len(path)] = n.key
+		out = walkEntries(it.bucket, sub, out)
+	}
+	return out
+}
+
+// compact write the live data to a snapshot segment and remove the segments before it.
+// The writable transactions are blocked only when taking the snapshot of the index and switching the segments.
+func (s *logStore) compact() error {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	s.e.mu.Lock()
+	if s.segs == nil {
+		s.e.mu.Unlock()
+		return nil
+	}
+	entries := walkEntries(s.e.root, nil, nil)
+	id := s.nextID
+	s.nextID++
+	old := len(s.segs)
+	dead := s.dead
+	err := s.rotate()
+	s.e.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// the old segments are not changed any more
+	fn := path.Join(s.dir, segmentName(id, logSnapExt))
+	f, err := os.OpenFile(fn+logTmpExt, os.O_CREATE|os.O_TRUNC|os.O_RDWR, fileMode)
+	if err != nil {
+		return err
+	}
+	snap := &logSegment{id: id, fn: fn, f: f}
+	offs := make([]int64, len(entries))
+	buf := newBatch()
+	flush := func() error {
+		if len(buf) == logHeaderSize {
+			return nil
+		}
+		sealBatch(buf)
+		_, err := f.WriteAt(buf, snap.size)
+		snap.size += int64(len(buf))
+		buf = newBatch()
+		return err
+	}
+	for i, en := range entries {
+		var value []byte
+		if en.op == logPut {
+			value, err = en.seg.readAt(en.off, en.size)
+		}
+		var pos int
+		buf, pos = appendOp(buf, en.op, en.path, en.key, value)
+		offs[i] = snap.size + int64(pos)
+		if err == nil && len(buf) >= logBatchSize {
+			err = flush()
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(fn+logTmpExt, fn)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(fn + logTmpExt)
+		return err
+	}
+	syncDir(s.dir)
+
+	s.e.mu.Lock()
+	defer s.e.mu.Unlock()
+	for i, en := range entries {
+		if en.op == logPut {
+			en.item.seg = snap
+			en.item.off = offs[i]
+		}
+	}
+	for _, seg := range s.segs[:old] {
+		seg.f.Close()
+		os.Remove(seg.fn)
+	}
+	s.segs = append([]*logSegment{snap}, s.segs[old:]...)
+	s.dead -= dead
+	if s.dead < 0 {
+		s.dead = 0
+	}
+	log.Printf("compact log:%s,segments:%d,useless bytes:%d,snapshot bytes:%d\n", s.dir, old, dead, snap.size)
+	return nil
+}
@@ -0,0 +1,350 @@
+package disk
+
+import (
+	"fmt"
+	"sync"
+)
+
+// memEngine the engine keeps all data in memory, for test and ephemeral chains.
+// The writable transaction records the undo of every change, Rollback applies them.
+// It is also the index of the log engine(log!=nil), the values are kept in the segment files.
+type memEngine struct {
+	mu   sync.RWMutex
+	root *memBucket
+	log  *logStore
+}
+
+type memBucket struct {
+	items *skipList
+}
+
+// memItem the value of the key, or the nested bucket
+type memItem struct {
+	value  []byte
+	bucket *memBucket
+	// the location of the value in the segment of log engine, seg=nil: the value is in memory
+	seg  *logSegment
+	off  int64
+	size int
+}
+
+type memTx struct {
+	e        *memEngine
+	writable bool
+	closed   bool
+	undo     []func()
+	// the changes written to the log by Commit, and the bytes of the log they made useless
+	ops  []logOp
+	dead int64
+	memTxBucket
+}
+
+// memTxBucket the bucket in the transaction, path: the names of the bucket from the root
+type memTxBucket struct {
+	tx   *memTx
+	b    *memBucket
+	path [][]byte
+}
+
+type memCursor struct {
+	b   *memBucket
+	key []byte
+}
+
+var (
+	errTxClosed      = fmt.Errorf("tx closed")
+	errTxNotWritable = fmt.Errorf("tx not writable")
+	errBucketExists  = fmt.Errorf("bucket already exists")
+	errBucketNotFind = fmt.Errorf("bucket not found")
+	errIncompatible  = fmt.Errorf("incompatible value")
+	errKeyRequired   = fmt.Errorf("key required")
+)
+
+func newMemEngine() *memEngine {
+	return &memEngine{root: newMemBucket()}
+}
+
+func newMemBucket() *memBucket {
+	return &memBucket{items: newSkipList()}
+}
+
+func (e *memEngine) Begin(writable bool) (Tx, error) {
+	if writable {
+		e.mu.Lock()
+	} else {
+		e.mu.RLock()
+	}
+	tx := &memTx{e: e, writable: writable}
+	tx.memTxBucket = memTxBucket{tx: tx, b: e.root}
+	return tx, nil
+}
+
+func (e *memEngine) View(fn func(tx Tx) error) error {
+	tx, _ := e.Begin(false)
+	defer tx.Rollback()
+	return fn(tx)
+}
+
+func (e *memEngine) Update(fn func(tx Tx) error) error {
+	tx, _ := e.Begin(true)
+	defer tx.Rollback()
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (e *memEngine) Close() error {
+	if e.log != nil {
+		return e.log.close()
+	}
+	return nil
+}
+
+func (t *memTx) Put(key, value []byte) error {
+	return errTxRoot
+}
+
+func (t *memTx) Delete(key []byte) error {
+	return errTxRoot
+}
+
+func (t *memTx) Commit() error {
+	if t.closed {
+		return errTxClosed
+	}
+	if !t.writable {
+		return errTxNotWritable
+	}
+	if t.e.log != nil && len(t.ops) > 0 {
+		err := t.e.log.write(t)
+		if err != nil {
+			t.Rollback()
+			return err
+		}
+	}
+	t.closed = true
+	t.undo = nil
+	t.ops = nil
+	t.e.mu.Unlock()
+	return nil
+}
+
+func (t *memTx) Rollback() error {
+	if t.closed {
+		return errTxClosed
+	}
+	t.closed = true
+	if !t.writable {
+		t.e.mu.RUnlock()
+		return nil
+	}
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+	t.undo = nil
+	t.ops = nil
+	t.e.mu.Unlock()
+	return nil
+}
+
+// set change the item of key and record the undo, item=nil: delete
+func (b memTxBucket) set(op byte, key []byte, item *memItem) error {
+	if b.tx.closed {
+		return errTxClosed
+	}
+	if !b.tx.writable {
+		return errTxNotWritable
+	}
+	var old interface{}
+	var ok bool
+	if item == nil {
+		old, ok = b.b.items.Delete(key)
+	} else {
+		old, ok = b.b.items.Put(key, item)
+	}
+	if b.tx.e.log != nil {
+		b.tx.ops = append(b.tx.ops, logOp{op, b.path, dup(key), item})
+		if ok {
+			b.tx.dead += old.(*memItem).logSize(b.path, key)
+		}
+		if item == nil {
+			b.tx.dead += logOpSize(b.path, key)
+		}
+	}
+	items := b.b.items
+	b.tx.undo = append(b.tx.undo, func() {
+		if ok {
+			items.Put(key, old)
+		} else {
+			items.Delete(key)
+		}
+	})
+	return nil
+}
+
+func (b memTxBucket) item(key []byte) *memItem {
+	v, ok := b.b.items.Get(key)
+	if !ok {
+		return nil
+	}
+	return v.(*memItem)
+}
+
+func (b memTxBucket) Get(key []byte) []byte {
+	it := b.item(key)
+	if it == nil || it.bucket != nil {
+		return nil
+	}
+	return it.getValue()
+}
+
+func (b memTxBucket) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyRequired
+	}
+	if it := b.item(key); it != nil && it.bucket != nil {
+		return errIncompatible
+	}
+	return b.set(logPut, dup(key), &memItem{value: dup(value)})
+}
+
+func (b memTxBucket) Delete(key []byte) error {
+	it := b.item(key)
+	if it == nil {
+		return nil
+	}
+	if it.bucket != nil {
+		return errIncompatible
+	}
+	return b.set(logDelete, key, nil)
+}
+
+func (b memTxBucket) Cursor() Cursor {
+	return &memCursor{b: b.b}
+}
+
+func (b memTxBucket) ForEach(fn func(k, v []byte) error) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		err := fn(k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b memTxBucket) Bucket(name []byte) Bucket {
+	it := b.item(name)
+	if it == nil || it.bucket == nil {
+		return nil
+	}
+	return memTxBucket{b.tx, it.bucket, b.subPath(name)}
+}
+
+// subPath return the path of the nested bucket
+func (b memTxBucket) subPath(name []byte) [][]byte {
+	out := make([][]byte, len(b.path)+1)
+	copy(out, b.path)
+	out[len(b.path)] = dup(name)
+	return out
+}
+
+func (b memTxBucket) CreateBucket(name []byte) (Bucket, error) {
+	if len(name) == 0 {
+		return nil, errKeyRequired
+	}
+	it := b.item(name)
+	if it != nil && it.bucket != nil {
+		return nil, errBucketExists
+	}
+	if it != nil {
+		return nil, errIncompatible
+	}
+	nb := newMemBucket()
+	err := b.set(logCreateBucket, dup(name), &memItem{bucket: nb})
+	if err != nil {
+		return nil, err
+	}
+	return memTxBucket{b.tx, nb, b.subPath(name)}, nil
+}
+
+func (b memTxBucket) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	if nb := b.Bucket(name); nb != nil {
+		return nb, nil
+	}
+	return b.CreateBucket(name)
+}
+
+func (b memTxBucket) DeleteBucket(name []byte) error {
+	it := b.item(name)
+	if it == nil {
+		return errBucketNotFind
+	}
+	if it.bucket == nil {
+		return errIncompatible
+	}
+	return b.set(logDeleteBucket, name, nil)
+}
+
+func (c *memCursor) result(n *skipNode) ([]byte, []byte) {
+	if n == nil {
+		c.key = nil
+		return nil, nil
+	}
+	c.key = n.key
+	it := n.value.(*memItem)
+	if it.bucket != nil {
+		return n.key, nil
+	}
+	return n.key, it.getValue()
+}
+
+func (c *memCursor) First() ([]byte, []byte) {
+	return c.result(c.b.items.First())
+}
+
+func (c *memCursor) Last() ([]byte, []byte) {
+	return c.result(c.b.items.Last())
+}
+
+func (c *memCursor) Next() ([]byte, []byte) {
+	if c.key == nil {
+		return nil, nil
+	}
+	return c.result(c.b.items.After(c.key))
+}
+
+func (c *memCursor) Prev() ([]byte, []byte) {
+	if c.key == nil {
+		return nil, nil
+	}
+	return c.result(c.b.items.Before(c.key))
+}
+
+func (c *memCursor) Seek(seek []byte) ([]byte, []byte) {
+	return c.result(c.b.items.Seek(seek))
+}
+
+// getValue return the value of item, it is read from the segment of log engine if not in memory
+func (it *memItem) getValue() []byte {
+	if it.seg == nil {
+		return it.value
+	}
+	return it.seg.read(it.off, it.size)
+}
+
+// logSize return the bytes of the log used by the item(and the items of the bucket)
+func (it *memItem) logSize(path [][]byte, key []byte) int64 {
+	if it.bucket == nil {
+		return logOpSize(path, key) + int64(it.size+len(it.value))
+	}
+	out := logOpSize(path, key)
+	sub := append(path[:len(path):len(path)], key)
+	for n := it.bucket.items.First(); n != nil; n = n.next[0] {
+		out += n.value.(*memItem).logSize(sub, n.key)
+	}
+	return out
+}
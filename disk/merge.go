@@ -0,0 +1,171 @@
+package disk
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// MergeFunc merge the operand into the old value, return the new value. empty value: delete the key
+type MergeFunc func(old, operand []byte) ([]byte, error)
+
+// the name of the merge operators
+const (
+	// MergeAppend append the operand to the old value
+	MergeAppend = "append"
+	// MergeMax keep the bigger one of the old value and the operand(compare as bytes)
+	MergeMax = "max"
+	// MergeAdd add the operand(8 bytes, BigEndian int64) to the counter(8 bytes, BigEndian uint64, empty is 0),
+	// return error if the counter underflow or overflow
+	MergeAdd = "add"
+)
+
+var (
+	mergeMu sync.Mutex
+	mergers = make(map[string]MergeFunc)
+)
+
+func init() {
+	RegisterMerge(MergeAppend, mergeAppend)
+	RegisterMerge(MergeMax, mergeMax)
+	RegisterMerge(MergeAdd, mergeAdd)
+}
+
+// RegisterMerge register the merge operator, Merge/MergeWithFlag select it by name
+func RegisterMerge(name string, fn MergeFunc) {
+	mergeMu.Lock()
+	defer mergeMu.Unlock()
+	mergers[name] = fn
+}
+
+func getMerge(name string) (MergeFunc, error) {
+	mergeMu.Lock()
+	fn := mergers[name]
+	mergeMu.Unlock()
+	if fn == nil {
+		return nil, fmt.Errorf("unknown merge operator:%s", name)
+	}
+	return fn, nil
+}
+
+func mergeAppend(old, operand []byte) ([]byte, error) {
+	out := make([]byte, 0, len(old)+len(operand))
+	out = append(out, old...)
+	return append(out, operand...), nil
+}
+
+func mergeMax(old, operand []byte) ([]byte, error) {
+	if bytes.Compare(operand, old) > 0 {
+		return dup(operand), nil
+	}
+	return dup(old), nil
+}
+
+func mergeAdd(old, operand []byte) ([]byte, error) {
+	if len(old) != 0 && len(old) != 8 {
+		return nil, fmt.Errorf("the old value is not a counter,length:%d", len(old))
+	}
+	if len(operand) != 8 {
+		return nil, fmt.Errorf("the operand is not a counter,length:%d", len(operand))
+	}
+	n := atoi(old)
+	delta := int64(atoi(operand))
+	if delta < 0 {
+		d := uint64(-(delta + 1)) + 1
+		if d > n {
+			return nil, fmt.Errorf("counter underflow:%d%d", n, delta)
+		}
+		return itoa(n - d), nil
+	}
+	if n+uint64(delta) < n {
+		return nil, fmt.Errorf("counter overflow:%d+%d", n, delta)
+	}
+	return itoa(n + uint64(delta)), nil
+}
+
+// Merge merge the operand into the value with the operator, unable rollback. Return the new value.
+func (m *Manager) Merge(tbName, key []byte, op string, operand []byte) ([]byte, error) {
+	fn, err := getMerge(op)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []byte
+	err = m.updateUnflagged([][]byte{tbName}, func(tx Tx) error {
+		b, err := tx.CreateBucketIfNotExists(getLocalTableName(ltnValue, tbName))
+		if err != nil {
+			log.Printf("fail to create bucket,%s\n", tbName)
+			return err
+		}
+		out, err = fn(getLiveValue(tx, tbName, key), operand)
+		if err != nil {
+			return err
+		}
+		err = clearExpire(tx, tbName, key)
+		if err != nil {
+			return err
+		}
+		err = putOrDelete(b, key, out)
+		if err != nil {
+			log.Println("fail to put:", key, err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MergeWithFlag merge the operand into the value with the operator, enable rollback. Return the new value.
+func (m *Manager) MergeWithFlag(flag, tbName, key []byte, op string, operand []byte) ([]byte, error) {
+	fn, err := getMerge(op)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fs := m.getFlag(flag)
+	if fs == nil {
+		log.Printf("Merge:not open flag:%x\n", flag)
+		return nil, fmt.Errorf("not open flag")
+	}
+	err = m.useFlagMode(fs, tbName)
+	if err != nil {
+		return nil, err
+	}
+	mk := memKey{}
+	mk.TbName = hex.EncodeToString(tbName)
+	mk.Key = hex.EncodeToString(key)
+	var old []byte
+	if v, ok := fs.lookup(mk); ok {
+		old = v.value
+	} else {
+		m.dataDb.View(func(tx Tx) error {
+			old = getValue(tx, ltnValue, tbName, key)
+			return nil
+		})
+	}
+	out, err := fn(old, operand)
+	if err != nil {
+		return nil, err
+	}
+	fs.set(tbName, key, out)
+	return out, nil
+}
+
+// Increment add delta to the counter(8 bytes, BigEndian uint64), unable rollback. Return the new value.
+// The absent counter is 0, return error if the counter underflow or overflow.
+func (m *Manager) Increment(tbName, key []byte, delta int64) (uint64, error) {
+	out, err := m.Merge(tbName, key, MergeAdd, itoa(uint64(delta)))
+	return atoi(out), err
+}
+
+// IncrementWithFlag add delta to the counter(8 bytes, BigEndian uint64), enable rollback. Return the new value.
+func (m *Manager) IncrementWithFlag(flag, tbName, key []byte, delta int64) (uint64, error) {
+	out, err := m.MergeWithFlag(flag, tbName, key, MergeAdd, itoa(uint64(delta)))
+	return atoi(out), err
+}
@@ -0,0 +1,122 @@
+package disk
+
+import (
+	"bytes"
+	"log"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	n, err := m.Increment(tbName, key, 5)
+	if err != nil || n != 5 {
+		t.Fatal("fail to increment:", n, err)
+	}
+	n, _ = m.Increment(tbName, key, -2)
+	if n != 3 || atoi(m.Get(tbName, key)) != 3 {
+		t.Error("error counter:", n)
+	}
+	if _, err = m.Increment(tbName, key, -4); err == nil {
+		t.Error("hope error when the counter underflow")
+	}
+	if n, _ = m.Increment(tbName, key, -3); n != 0 || atoi(m.Get(tbName, key)) != 0 {
+		t.Error("error counter:", n)
+	}
+	absent := []byte("absent")
+	if _, err = m.Increment(tbName, absent, -1); err == nil || m.Exist(tbName, absent) {
+		t.Error("hope error when decrease the absent counter")
+	}
+	n, err = m.Increment(tbName, absent, math.MaxInt64)
+	if err != nil || n != math.MaxInt64 {
+		t.Fatal("fail to increment the absent counter:", n, err)
+	}
+	n, _ = m.Increment(tbName, absent, math.MaxInt64)
+	if n != math.MaxUint64-1 {
+		t.Error("error counter:", n)
+	}
+	if _, err = m.Increment(tbName, absent, 2); err == nil || atoi(m.Get(tbName, absent)) != math.MaxUint64-1 {
+		t.Error("hope error when the counter overflow")
+	}
+	if n, _ = m.Increment(tbName, absent, math.MinInt64); n != math.MaxInt64-1 {
+		t.Error("error counter after decrease min int64:", n)
+	}
+	v, err := m.Merge(tbName, key2, MergeAppend, value)
+	if err != nil {
+		t.Fatal("fail to merge.", err)
+	}
+	v, _ = m.Merge(tbName, key2, MergeAppend, value2)
+	if bytes.Compare(v, append(dup(value), value2...)) != 0 {
+		t.Errorf("error append:%s", v)
+	}
+	m.Merge(tbName, key3, MergeMax, value2)
+	v, _ = m.Merge(tbName, key3, MergeMax, value)
+	if bytes.Compare(v, value2) != 0 || bytes.Compare(m.Get(tbName, key3), value2) != 0 {
+		t.Errorf("error max:%s", v)
+	}
+	if _, err = m.Merge(tbName, key2, MergeAdd, itoa(1)); err == nil {
+		t.Error("hope error when add to the value which is not a counter")
+	}
+	if _, err = m.Merge(tbName, key2, "not exist", value); err == nil {
+		t.Error("hope error with unknown operator")
+	}
+
+	RegisterMerge("delete", func(old, operand []byte) ([]byte, error) {
+		return nil, nil
+	})
+	m.Merge(tbName, key2, "delete", nil)
+	if m.Exist(tbName, key2) {
+		t.Error("hope the key is deleted by the registered operator")
+	}
+}
+
+func TestMergeWithFlag(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	m.OpenFlag(flag)
+	m.IncrementWithFlag(flag, tbName, key, 10)
+	m.Commit(flag)
+
+	m.OpenFlag(flag2)
+	n, err := m.IncrementWithFlag(flag2, tbName, key, 1)
+	if err != nil || n != 11 {
+		t.Fatal("fail to increment with flag:", n, err)
+	}
+	n, _ = m.IncrementWithFlag(flag2, tbName, key, 1)
+	if n != 12 || atoi(m.Get(tbName, key)) != 12 {
+		t.Error("error counter:", n)
+	}
+	if _, err = m.IncrementWithFlag(flag2, tbName, key, -13); err == nil || atoi(m.Get(tbName, key)) != 12 {
+		t.Error("hope error when the counter underflow")
+	}
+	if _, err = m.IncrementWithFlag(flag2, tbName, key2, -1); err == nil || m.Exist(tbName, key2) {
+		t.Error("hope error when decrease the absent counter")
+	}
+	m.MergeWithFlag(flag2, tbName, key2, MergeAppend, value)
+	m.Commit(flag2)
+	if _, err = m.Increment(tbName, key, 1); err == nil {
+		t.Error("hope error when merge without flag on flagged table")
+	}
+
+	err = m.Rollback(flag2)
+	if err != nil {
+		t.Fatal("fail to rollback.", err)
+	}
+	if atoi(m.Get(tbName, key)) != 10 || m.Exist(tbName, key2) {
+		t.Error("hope the merged values are rolled back:", atoi(m.Get(tbName, key)))
+	}
+}
@@ -0,0 +1,37 @@
+package disk
+
+import (
+	"time"
+)
+
+// flagTime the bucket of flag.db, flag -> the time of commit(unix nano), used by HistoryAge
+const flagTime = "flag_time"
+
+// DefaultHistoryMax the default number of the retained history(rollback times)
+const DefaultHistoryMax = 2000
+
+// Options the options of manager, the zero value of a field means the default value
+type Options struct {
+	// HistoryMax retain the history of the last HistoryMax flags, default: DefaultHistoryMax
+	HistoryMax uint64
+	// HistoryBytes retain the history not more than HistoryBytes in total, 0: no limit
+	HistoryBytes int64
+	// HistoryAge retain the history of the flags committed in HistoryAge, 0: no limit
+	HistoryAge time.Duration
+	// Engine the name of the storage engine(see RegisterEngine), default: EngineBolt
+	Engine string
+	// ReapInterval the interval of deleting the expired keys(see SetWithTTL), default: DefaultReapInterval
+	ReapInterval time.Duration
+}
+
+func (o *Options) init() {
+	if o.HistoryMax == 0 {
+		o.HistoryMax = DefaultHistoryMax
+	}
+	if o.Engine == "" {
+		o.Engine = EngineBolt
+	}
+	if o.ReapInterval <= 0 {
+		o.ReapInterval = DefaultReapInterval
+	}
+}
@@ -0,0 +1,219 @@
+package disk
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/boltdb/bolt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// RecoveryReport the result of the recovery when the manager is opened
+type RecoveryReport struct {
+	// Journal the state of the commit journal: JournalNotFound, JournalReplayed or JournalDiscarded
+	Journal string
+	// JournalFlag the flag of the replayed journal
+	JournalFlag []byte
+	// LastFlag the last flag of the flag list, found before recovery
+	LastFlag []byte
+	// CommittedFlag the flag recorded as committed, found before recovery
+	CommittedFlag []byte
+	// RolledBack the flags(from the last one) rolled back because the commit or rollback was not finished
+	RolledBack [][]byte
+	// RemovedFiles the orphan history removed: the hex of flag, or the history file(.h) of the old version
+	RemovedFiles []string
+}
+
+// the state of the commit journal
+const (
+	JournalNotFound  = ""
+	JournalReplayed  = "replayed"
+	JournalDiscarded = "discarded"
+)
+
+// RecoveryReport return the result of the recovery when the manager was opened
+func (m *Manager) RecoveryReport() RecoveryReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.report
+}
+
+// recover replay the commit journal, finish the unfinished rollback and remove the orphan history
+func (m *Manager) recover() error {
+	r := &m.report
+	err := m.replayJournal(r)
+	if err != nil {
+		return err
+	}
+
+	m.flagDb.View(func(tx Tx) error {
+		b := tx.Bucket([]byte(flagList))
+		c := b.Cursor()
+		_, v1 := c.Last()
+		_, v2 := c.First()
+		if v1 != nil {
+			r.LastFlag = dup(v1)
+		}
+		if v2 != nil {
+			r.CommittedFlag = dup(v2)
+		}
+		return nil
+	})
+	if bytes.Compare(r.LastFlag, r.CommittedFlag) != 0 {
+		log.Printf("different flag,rollback to:%x,last:%x\n", r.CommittedFlag, r.LastFlag)
+		flags, err := m.flagsAfter(r.CommittedFlag)
+		if err != nil {
+			return err
+		}
+		err = m.rollback(flags, r.CommittedFlag, true)
+		if err != nil {
+			return err
+		}
+		r.RolledBack = flags
+	}
+
+	r.RemovedFiles = m.removeOrphanHistory()
+	_, err = m.prune()
+	return err
+}
+
+// removeOrphanHistory remove the history which flag is not in the flag list,
+// return the hex of the flags, and the history files(.h) left by the old version
+func (m *Manager) removeOrphanHistory() []string {
+	flags := make(map[string]bool)
+	m.flagDb.View(func(tx Tx) error {
+		b := tx.Bucket([]byte(flagList))
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && atoi(k) > 0; k, v = c.Prev() {
+			flags[string(v)] = true
+		}
+		return nil
+	})
+	var orphans [][]byte
+	m.historyDb.View(func(tx Tx) error {
+		return tx.Bucket([]byte(historyIndex)).ForEach(func(k, _ []byte) error {
+			if !flags[string(k)] {
+				orphans = append(orphans, dup(k))
+			}
+			return nil
+		})
+	})
+	var out []string
+	if len(orphans) > 0 {
+		err := m.removeHistory(orphans...)
+		if err != nil {
+			log.Println("fail to remove orphan history:", err)
+			return nil
+		}
+		for _, f := range orphans {
+			log.Printf("remove orphan history:%x\n", f)
+			out = append(out, hex.EncodeToString(f))
+		}
+	}
+
+	if m.inMemory() {
+		return out
+	}
+	files, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		log.Println("fail to read dir:", m.dir, err)
+		return out
+	}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, ".h") {
+			continue
+		}
+		err = os.Remove(path.Join(m.dir, name))
+		if err != nil {
+			log.Println("fail to remove orphan history file:", name, err)
+			continue
+		}
+		log.Println("remove orphan history file:", name)
+		out = append(out, name)
+	}
+	return out
+}
+
+// migrateHistory move the history files(<hex flag>.h) of the old version to history.db.
+// The file is removed after it is moved, the files not in the flag list are left to removeOrphanHistory.
+func (m *Manager) migrateHistory() error {
+	if m.inMemory() {
+		return nil
+	}
+	files, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		log.Println("fail to read dir:", m.dir, err)
+		return err
+	}
+	seqs := make(map[string]uint64)
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, ".h") {
+			continue
+		}
+		if len(seqs) == 0 {
+			m.flagDb.View(func(tx Tx) error {
+				c := tx.Bucket([]byte(flagList)).Cursor()
+				for k, v := c.Seek(itoa(1)); k != nil; k, v = c.Next() {
+					seqs[hex.EncodeToString(v)] = atoi(k)
+				}
+				return nil
+			})
+		}
+		seq, ok := seqs[strings.TrimSuffix(name, ".h")]
+		if !ok {
+			continue
+		}
+		flag, _ := hex.DecodeString(strings.TrimSuffix(name, ".h"))
+		err = m.migrateHistoryFile(path.Join(m.dir, name), flag, seq)
+		if err != nil {
+			return err
+		}
+		os.Remove(path.Join(m.dir, name))
+		log.Println("migrate history file:", name)
+	}
+	return nil
+}
+
+func (m *Manager) migrateHistoryFile(fn string, flag []byte, seq uint64) error {
+	history, err := bolt.Open(fn, fileMode, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		log.Println("fail to open flag file:", fn, err)
+		return err
+	}
+	defer history.Close()
+	return history.View(func(tx1 *bolt.Tx) error {
+		return m.historyDb.Update(func(tx Tx) error {
+			name := itoa(seq)
+			if tx.Bucket(name) != nil {
+				err := tx.DeleteBucket(name)
+				if err != nil {
+					return err
+				}
+			}
+			hb, err := tx.CreateBucket(name)
+			if err != nil {
+				return err
+			}
+			var size int
+			err = tx1.ForEach(func(bn []byte, b1 *bolt.Bucket) error {
+				b2, err := hb.CreateBucket(dup(bn))
+				if err != nil {
+					return err
+				}
+				return b1.ForEach(func(k, v []byte) error {
+					size += len(k) + len(v)
+					return b2.Put(dup(k), dup(v))
+				})
+			})
+			if err != nil {
+				return err
+			}
+			return tx.Bucket([]byte(historyIndex)).Put(flag, append(name, itoa(uint64(size))...))
+		})
+	})
+}
@@ -0,0 +1,180 @@
+package disk
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestRecovery(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	flags := [][]byte{flag, flag2, flag3}
+	values := [][]byte{value, value2, value3}
+	for i, f := range flags {
+		m.OpenFlag(f)
+		m.SetWithFlag(f, tbName, key, values[i])
+		err = m.Commit(f)
+		if err != nil {
+			t.Fatal("fail to commit.", err)
+		}
+	}
+	r := m.RecoveryReport()
+	if r.Journal != JournalNotFound || len(r.RolledBack) > 0 || len(r.RemovedFiles) > 0 {
+		t.Errorf("error report:%#v", r)
+	}
+	// simulate a crash after RollbackTo(flag) recorded the committed flag
+	m.flagDb.Update(func(tx Tx) error {
+		return tx.Bucket([]byte(flagList)).Put(itoa(0), flag)
+	})
+	m.Close()
+	orphan := "0123.h"
+	ioutil.WriteFile(path.Join(testDir, orphan), nil, fileMode)
+
+	m, err = Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	r = m.RecoveryReport()
+	if bytes.Compare(r.LastFlag, flag3) != 0 || bytes.Compare(r.CommittedFlag, flag) != 0 {
+		t.Errorf("error flags of report,last:%s,committed:%s", r.LastFlag, r.CommittedFlag)
+	}
+	if len(r.RolledBack) != 2 || bytes.Compare(r.RolledBack[0], flag3) != 0 ||
+		bytes.Compare(r.RolledBack[1], flag2) != 0 {
+		t.Errorf("error rolled back flags:%q", r.RolledBack)
+	}
+	if len(r.RemovedFiles) != 1 || r.RemovedFiles[0] != orphan {
+		t.Errorf("error removed files:%v", r.RemovedFiles)
+	}
+	v := m.Get(tbName, key)
+	if bytes.Compare(v, value) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value, v)
+	}
+	lf := m.GetLastFlag()
+	if bytes.Compare(lf, flag) != 0 {
+		t.Errorf("different last flag,hope:%s,get:%s", flag, lf)
+	}
+	if err := m.checkHistory(flag); err != nil {
+		t.Error("the history of last flag should not be removed.", err)
+	}
+}
+
+func TestMigrateHistory(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key, value)
+	m.Commit(flag)
+	m.OpenFlag(flag2)
+	m.SetWithFlag(flag2, tbName, key, value2)
+	m.Commit(flag2)
+	// simulate the history file of the old version
+	m.removeHistory(flag2)
+	m.Close()
+	history, err := bolt.Open(m.getHistoryFileName(flag2), fileMode, nil)
+	if err != nil {
+		t.Fatal("fail to create history file", err)
+	}
+	history.Update(func(tx *bolt.Tx) error {
+		data := map[byte][]byte{ltnFlag: flag, ltnPreValue: value, ltnValue: value2}
+		for typ, v := range data {
+			b, _ := tx.CreateBucket(getLocalTableName(typ, tbName))
+			b.Put(key, v)
+		}
+		return nil
+	})
+	history.Close()
+
+	m, err = Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	if _, err := os.Stat(m.getHistoryFileName(flag2)); !os.IsNotExist(err) {
+		t.Error("hope the history file is removed after migration")
+	}
+	if r := m.RecoveryReport(); len(r.RemovedFiles) > 0 {
+		t.Errorf("error removed files:%v", r.RemovedFiles)
+	}
+	v, err := m.GetAt(flag, tbName, key)
+	if err != nil || bytes.Compare(v, value) != 0 {
+		t.Errorf("different value,hope:%s,get:%s,%v", value, v, err)
+	}
+	err = m.Rollback(flag2)
+	if err != nil {
+		t.Fatal("fail to rollback.", err)
+	}
+	v = m.Get(tbName, key)
+	if bytes.Compare(v, value) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value, v)
+	}
+}
+
+func TestRollbackFail(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	defer func() { commitHook = func(string) error { return nil } }()
+	for _, step := range []string{stepRollbackFlag, stepRollbackData} {
+		os.RemoveAll(testDir)
+		m, err := Open(testDir, nil)
+		if err != nil {
+			t.Fatal("fail to open dir", err)
+		}
+		flags := [][]byte{flag, flag2, flag3}
+		values := [][]byte{value, value2, value3}
+		for i, f := range flags {
+			m.OpenFlag(f)
+			m.SetWithFlag(f, tbName, key, values[i])
+			m.Commit(f)
+		}
+		commitHook = func(s string) error {
+			if s == step {
+				return fmt.Errorf("failure at %s", s)
+			}
+			return nil
+		}
+		if m.RollbackTo(flag) == nil {
+			t.Fatal("hope error when rollback fails:", step)
+		}
+		commitHook = func(string) error { return nil }
+		if m.OpenFlag(flag2) == nil || m.RollbackTo(flag) == nil {
+			t.Error("hope error when write after the failed rollback:", step)
+		}
+		m.Close()
+
+		m, err = Open(testDir, nil)
+		if err != nil {
+			t.Fatal("fail to open dir after the failed rollback:", step, err)
+		}
+		r := m.RecoveryReport()
+		if len(r.RolledBack) != 2 || bytes.Compare(r.RolledBack[0], flag3) != 0 ||
+			bytes.Compare(r.RolledBack[1], flag2) != 0 {
+			t.Errorf("error rolled back flags,step:%s,flags:%q", step, r.RolledBack)
+		}
+		v := m.Get(tbName, key)
+		lf := m.GetLastFlag()
+		if bytes.Compare(v, value) != 0 || bytes.Compare(lf, flag) != 0 {
+			t.Errorf("hope the rollback is finished,step:%s,last flag:%s,value:%s", step, lf, v)
+		}
+		if _, err = m.GetCommitHash(flag2); err == nil {
+			t.Error("hope the hash of the rolled back flag is removed:", step)
+		}
+		m.Close()
+	}
+}
@@ -0,0 +1,126 @@
+package disk
+
+import (
+	"fmt"
+	"log"
+)
+
+// savepoint record the cache before the changes after the savepoint
+type savepoint struct {
+	id uint64
+	// undo the value before the first change of the key, nil: the key was not in cache
+	undo map[memKey]*memValue
+	// tables and dropped the tables of the flag when the savepoint is created, see flagState
+	tables  map[string]bool
+	dropped map[string]bool
+}
+
+// Savepoint create a savepoint in the opened flag, return the id of the savepoint.
+// The changes after the savepoint can be undone by RollbackToSavepoint, not touch disk.
+func (m *Manager) Savepoint(flag []byte) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fs := m.getFlag(flag)
+	if fs == nil {
+		log.Printf("Savepoint:not open flag:%x\n", flag)
+		return 0, fmt.Errorf("not open flag")
+	}
+	m.spID++
+	sp := &savepoint{id: m.spID, undo: make(map[memKey]*memValue)}
+	sp.tables = copySet(fs.tables)
+	sp.dropped = copySet(fs.dropped)
+	fs.savepoints = append(fs.savepoints, sp)
+	return sp.id, nil
+}
+
+// RollbackToSavepoint undo the changes after the savepoint,
+// the savepoints created after it are released, the savepoint itself is kept.
+func (m *Manager) RollbackToSavepoint(flag []byte, id uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fs, i, err := m.findSavepoint(flag, id)
+	if err != nil {
+		return err
+	}
+	if m.hasChild(fs) {
+		log.Printf("try to rollback the flag which has child flags:%x\n", flag)
+		return fmt.Errorf("exist child flag")
+	}
+	for j := len(fs.savepoints) - 1; j >= i; j-- {
+		for mk, mv := range fs.savepoints[j].undo {
+			if mv == nil {
+				fs.remove(mk)
+				continue
+			}
+			fs.put(mk, mv)
+		}
+	}
+	fs.savepoints = fs.savepoints[:i+1]
+	fs.savepoints[i].undo = make(map[memKey]*memValue)
+	// the mode declared after the savepoint is undone
+	fs.tables = copySet(fs.savepoints[i].tables)
+	fs.dropped = copySet(fs.savepoints[i].dropped)
+	return nil
+}
+
+// ReleaseSavepoint release the savepoint and the savepoints created after it, keep the changes
+func (m *Manager) ReleaseSavepoint(flag []byte, id uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fs, i, err := m.findSavepoint(flag, id)
+	if err != nil {
+		return err
+	}
+	// the previous savepoint need the oldest value of the keys
+	if i > 0 {
+		pre := fs.savepoints[i-1]
+		for _, sp := range fs.savepoints[i:] {
+			for mk, mv := range sp.undo {
+				if _, ok := pre.undo[mk]; !ok {
+					pre.undo[mk] = mv
+				}
+			}
+		}
+	}
+	fs.savepoints = fs.savepoints[:i]
+	return nil
+}
+
+func copySet(in map[string]bool) map[string]bool {
+	out := make(map[string]bool, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+func (m *Manager) findSavepoint(flag []byte, id uint64) (*flagState, int, error) {
+	fs := m.getFlag(flag)
+	if fs == nil {
+		log.Printf("Savepoint:not open flag:%x\n", flag)
+		return nil, 0, fmt.Errorf("not open flag")
+	}
+	for i, sp := range fs.savepoints {
+		if sp.id == id {
+			return fs, i, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("not found savepoint")
+}
+
+// saveUndo record the value of the key before it is changed, mv=nil: the key is not in cache
+func (fs *flagState) saveUndo(mk memKey, mv *memValue) {
+	if len(fs.savepoints) == 0 {
+		return
+	}
+	sp := fs.savepoints[len(fs.savepoints)-1]
+	if _, ok := sp.undo[mk]; ok {
+		return
+	}
+	if mv == nil {
+		sp.undo[mk] = nil
+		return
+	}
+	old := *mv
+	sp.undo[mk] = &old
+}
@@ -0,0 +1,121 @@
+package disk
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestSavepoint(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	_, err = m.Savepoint(flag)
+	if err == nil {
+		t.Error("hope error without opened flag")
+	}
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key, value)
+	sp1, err := m.Savepoint(flag)
+	if err != nil {
+		t.Fatal("fail to create savepoint.", err)
+	}
+	m.SetWithFlag(flag, tbName, key, value2)
+	m.SetWithFlag(flag, tbName, key2, value2)
+	sp2, _ := m.Savepoint(flag)
+	m.SetWithFlag(flag, tbName, key, value3)
+	m.DeleteWithFlag(flag, tbName, key2)
+
+	err = m.RollbackToSavepoint(flag, sp2)
+	if err != nil {
+		t.Fatal("fail to rollback to savepoint.", err)
+	}
+	v := m.Get(tbName, key)
+	if bytes.Compare(v, value2) != 0 || !m.Exist(tbName, key2) {
+		t.Errorf("different value,hope:%s,get:%s", value2, v)
+	}
+	err = m.RollbackToSavepoint(flag, sp1)
+	if err != nil {
+		t.Fatal("fail to rollback to savepoint.", err)
+	}
+	v = m.Get(tbName, key)
+	if bytes.Compare(v, value) != 0 || m.Exist(tbName, key2) {
+		t.Errorf("different value,hope:%s,get:%s", value, v)
+	}
+	err = m.RollbackToSavepoint(flag, sp2)
+	if err == nil {
+		t.Error("hope the savepoint2 is released")
+	}
+
+	// sp1 is kept after rollback
+	m.SetWithFlag(flag, tbName, key3, value3)
+	sp3, _ := m.Savepoint(flag)
+	m.SetWithFlag(flag, tbName, key, value3)
+	err = m.ReleaseSavepoint(flag, sp3)
+	if err != nil {
+		t.Fatal("fail to release savepoint.", err)
+	}
+	v = m.Get(tbName, key)
+	if bytes.Compare(v, value3) != 0 {
+		t.Errorf("different value,hope:%s,get:%s", value3, v)
+	}
+	m.RollbackToSavepoint(flag, sp1)
+	v = m.Get(tbName, key)
+	if bytes.Compare(v, value) != 0 || m.Exist(tbName, key3) {
+		t.Errorf("different value,hope:%s,get:%s", value, v)
+	}
+
+	m.SetWithFlag(flag, tbName, key2, value2)
+	m.ReleaseSavepoint(flag, sp1)
+	err = m.Commit(flag)
+	if err != nil {
+		t.Fatal("fail to commit.", err)
+	}
+	v = m.Get(tbName, key2)
+	if bytes.Compare(v, value2) != 0 || m.Exist(tbName, key3) {
+		t.Errorf("different value,hope:%s,get:%s", value2, v)
+	}
+	m.Rollback(flag)
+	if m.Exist(tbName, key) || m.Exist(tbName, key2) {
+		t.Error("hope not exist after rollback")
+	}
+}
+
+func TestSavepointTableMode(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	tbName2 := []byte("tbName2")
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key, value)
+	sp, _ := m.Savepoint(flag)
+	m.SetWithFlag(flag, tbName2, key, value)
+	if mode := m.GetTableMode(tbName2); mode != TableModeFlagged {
+		t.Error("hope the mode is declared by the flag:", mode)
+	}
+	err = m.RollbackToSavepoint(flag, sp)
+	if err != nil {
+		t.Fatal("fail to rollback to savepoint.", err)
+	}
+	if mode := m.GetTableMode(tbName2); mode != TableModeNone {
+		t.Error("hope the mode declared after the savepoint is undone:", mode)
+	}
+	if mode := m.GetTableMode(tbName); mode != TableModeFlagged {
+		t.Error("hope the mode declared before the savepoint is kept:", mode)
+	}
+	err = m.Set(tbName2, key, value)
+	if err != nil {
+		t.Error("fail to write the table without flag.", err)
+	}
+}
@@ -0,0 +1,151 @@
+package disk
+
+import (
+	"bytes"
+)
+
+const skipMaxLevel = 24
+
+// skipList the ordered map of keys, used by the engines without bolt
+type skipList struct {
+	head   *skipNode
+	level  int
+	length int
+	seed   uint64
+}
+
+type skipNode struct {
+	key   []byte
+	value interface{}
+	next  []*skipNode
+}
+
+func newSkipList() *skipList {
+	return &skipList{head: &skipNode{next: make([]*skipNode, skipMaxLevel)}, level: 1, seed: 0x2545F4914F6CDD1D}
+}
+
+func (s *skipList) randomLevel() int {
+	// xorshift
+	s.seed ^= s.seed << 13
+	s.seed ^= s.seed >> 7
+	s.seed ^= s.seed << 17
+	level := 1
+	for r := s.seed; level < skipMaxLevel && r&3 == 0; r >>= 2 {
+		level++
+	}
+	return level
+}
+
+// find return the last nodes before key of every level
+func (s *skipList) find(key []byte, update []*skipNode) *skipNode {
+	x := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && bytes.Compare(x.next[i].key, key) < 0 {
+			x = x.next[i]
+		}
+		if update != nil {
+			update[i] = x
+		}
+	}
+	return x
+}
+
+// Len return the number of keys
+func (s *skipList) Len() int {
+	return s.length
+}
+
+// Get return the value of key
+func (s *skipList) Get(key []byte) (interface{}, bool) {
+	n := s.Seek(key)
+	if n == nil || bytes.Compare(n.key, key) != 0 {
+		return nil, false
+	}
+	return n.value, true
+}
+
+// Put set the value of key, return the old value
+func (s *skipList) Put(key []byte, value interface{}) (interface{}, bool) {
+	update := make([]*skipNode, skipMaxLevel)
+	x := s.find(key, update).next[0]
+	if x != nil && bytes.Compare(x.key, key) == 0 {
+		old := x.value
+		x.value = value
+		return old, true
+	}
+	level := s.randomLevel()
+	for i := s.level; i < level; i++ {
+		update[i] = s.head
+	}
+	if level > s.level {
+		s.level = level
+	}
+	n := &skipNode{key: key, value: value, next: make([]*skipNode, level)}
+	for i := 0; i < level; i++ {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+	s.length++
+	return nil, false
+}
+
+// Delete delete the key, return the old value
+func (s *skipList) Delete(key []byte) (interface{}, bool) {
+	update := make([]*skipNode, skipMaxLevel)
+	x := s.find(key, update).next[0]
+	if x == nil || bytes.Compare(x.key, key) != 0 {
+		return nil, false
+	}
+	for i := 0; i < len(x.next); i++ {
+		if update[i].next[i] == x {
+			update[i].next[i] = x.next[i]
+		}
+	}
+	for s.level > 1 && s.head.next[s.level-1] == nil {
+		s.level--
+	}
+	s.length--
+	return x.value, true
+}
+
+// First return the first node, nil if empty
+func (s *skipList) First() *skipNode {
+	return s.head.next[0]
+}
+
+// Last return the last node, nil if empty
+func (s *skipList) Last() *skipNode {
+	x := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for x.next[i] != nil {
+			x = x.next[i]
+		}
+	}
+	if x == s.head {
+		return nil
+	}
+	return x
+}
+
+// Seek return the first node >= key
+func (s *skipList) Seek(key []byte) *skipNode {
+	return s.find(key, nil).next[0]
+}
+
+// After return the first node > key
+func (s *skipList) After(key []byte) *skipNode {
+	n := s.Seek(key)
+	if n != nil && bytes.Compare(n.key, key) == 0 {
+		n = n.next[0]
+	}
+	return n
+}
+
+// Before return the last node < key
+func (s *skipList) Before(key []byte) *skipNode {
+	x := s.find(key, nil)
+	if x == s.head {
+		return nil
+	}
+	return x
+}
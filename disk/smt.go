@@ -0,0 +1,240 @@
+package disk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+
+	"github.com/lengzhao/database/smt"
+)
+
+// The sparse merkle tree over the data written with flag, stored in data.db(bucket smtNode).
+// The hashing is in package smt, it is shared with the client.
+// The tree is updated in the same transaction as the data, so the root is always the root of data.db.
+// The data written by Set/Delete(without flag) are not in the tree.
+const (
+	smtNode = "smt_node"
+	// flagRoot the bucket of flag.db, flag -> the state root after the flag committed
+	flagRoot = "flag_root"
+)
+
+// Proof the proof of a key, verify it by VerifyProof(same as smt.Proof)
+type Proof = smt.Proof
+
+// smtNodeKey the key of the node: depth(2 bytes) + the first depth bits of path
+func smtNodeKey(depth int, path []byte) []byte {
+	out := make([]byte, 2+sha256.Size)
+	binary.BigEndian.PutUint16(out, uint16(depth))
+	for i := 0; i < depth; i++ {
+		if smt.Bit(path, i) == 1 {
+			out[2+i/8] |= 1 << (7 - uint(i%8))
+		}
+	}
+	return out
+}
+
+// smtGet return the node: type, hash, path and value hash of leaf. typ=-1: empty
+func smtGet(b Bucket, depth int, path []byte) (typ int, hash, lp, lvh []byte) {
+	v := b.Get(smtNodeKey(depth, path))
+	if len(v) == 0 {
+		return -1, smt.Empty, nil, nil
+	}
+	if v[0] == smt.Inner {
+		return smt.Inner, v[1:], nil, nil
+	}
+	lp, lvh = v[1:1+sha256.Size], v[1+sha256.Size:]
+	return smt.Leaf, smt.LeafHash(lp, lvh), lp, lvh
+}
+
+func smtPutLeaf(b Bucket, depth int, path, valueHash []byte) error {
+	v := append([]byte{smt.Leaf}, path...)
+	v = append(v, valueHash...)
+	return b.Put(smtNodeKey(depth, path), v)
+}
+
+// smtUpdate set the value hash of path in the subtree at depth, valueHash=nil: delete
+func smtUpdate(b Bucket, depth int, path, valueHash []byte) error {
+	nk := smtNodeKey(depth, path)
+	typ, _, lp, lvh := smtGet(b, depth, path)
+	switch {
+	case typ == -1:
+		if valueHash == nil {
+			return nil
+		}
+		return smtPutLeaf(b, depth, path, valueHash)
+	case typ == smt.Leaf && bytes.Compare(lp, path) == 0:
+		if valueHash == nil {
+			return b.Delete(nk)
+		}
+		return smtPutLeaf(b, depth, path, valueHash)
+	case typ == smt.Leaf:
+		if valueHash == nil {
+			return nil
+		}
+		// move the leaf down, then insert path into the subtree
+		lp, lvh = dup(lp), dup(lvh)
+		err := b.Delete(nk)
+		if err != nil {
+			return err
+		}
+		err = smtPutLeaf(b, depth+1, lp, lvh)
+		if err != nil {
+			return err
+		}
+	}
+	err := smtUpdate(b, depth+1, path, valueHash)
+	if err != nil {
+		return err
+	}
+
+	// update the hash of the node
+	sibling := dup(path)
+	i := depth / 8
+	sibling[i] ^= 1 << (7 - uint(depth%8))
+	t1, h1, lp1, lvh1 := smtGet(b, depth+1, path)
+	t2, h2, lp2, lvh2 := smtGet(b, depth+1, sibling)
+	switch {
+	case t1 == -1 && t2 == -1:
+		return b.Delete(nk)
+	case t1 == -1 && t2 == smt.Leaf:
+		lp2, lvh2 = dup(lp2), dup(lvh2)
+		b.Delete(smtNodeKey(depth+1, sibling))
+		return smtPutLeaf(b, depth, lp2, lvh2)
+	case t2 == -1 && t1 == smt.Leaf:
+		lp1, lvh1 = dup(lp1), dup(lvh1)
+		b.Delete(smtNodeKey(depth+1, path))
+		return smtPutLeaf(b, depth, lp1, lvh1)
+	}
+	left, right := h1, h2
+	if smt.Bit(path, depth) == 1 {
+		left, right = h2, h1
+	}
+	return b.Put(nk, append([]byte{smt.Inner}, smt.InnerHash(left, right)...))
+}
+
+// smtSet update the tree with the value of the key, empty value: delete
+func smtSet(tx Tx, tbName, key, value []byte) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(smtNode))
+	if err != nil {
+		log.Println("fail to create bucket(smt):", err)
+		return err
+	}
+	var vh []byte
+	if len(value) > 0 {
+		vh = smt.ValueHash(value)
+	}
+	return smtUpdate(b, 0, smt.Path(tbName, key), vh)
+}
+
+// smtRoot return the root of the tree
+func smtRoot(tx Tx) []byte {
+	b := tx.Bucket([]byte(smtNode))
+	if b == nil {
+		return smt.Empty
+	}
+	_, h, _, _ := smtGet(b, 0, make([]byte, sha256.Size))
+	return dup(h)
+}
+
+// smtInit build the tree from the data written with flag, when the tree not exist
+func smtInit(tx Tx) error {
+	if tx.Bucket([]byte(smtNode)) != nil {
+		return nil
+	}
+	_, err := tx.CreateBucket([]byte(smtNode))
+	if err != nil {
+		log.Println("fail to create bucket(smt):", err)
+		return err
+	}
+	var names [][]byte
+	tx.ForEach(func(name, _ []byte) error {
+		if name[0] == ltnFlag {
+			names = append(names, dup(name))
+		}
+		return nil
+	})
+	for _, name := range names {
+		tn := name[1:]
+		var keys [][]byte
+		tx.Bucket(name).ForEach(func(k, _ []byte) error {
+			keys = append(keys, dup(k))
+			return nil
+		})
+		for _, k := range keys {
+			err = smtSet(tx, tn, k, getValue(tx, ltnValue, tn, k))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetStateRoot return the state root after the flag committed
+func (m *Manager) GetStateRoot(flag []byte) ([]byte, error) {
+	var out []byte
+	m.flagDb.View(func(tx Tx) error {
+		b := tx.Bucket([]byte(flagRoot))
+		if b == nil {
+			return nil
+		}
+		if v := b.Get(flag); len(v) > 0 {
+			out = dup(v)
+		}
+		return nil
+	})
+	if out == nil {
+		return nil, fmt.Errorf("not found state root")
+	}
+	return out, nil
+}
+
+// GetWithProof get the committed data(not the data of opened flag) and the proof of it.
+func (m *Manager) GetWithProof(tbName, key []byte) ([]byte, *Proof, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []byte
+	proof := new(Proof)
+	m.flagDb.View(func(tx Tx) error {
+		_, v := tx.Bucket([]byte(flagList)).Cursor().First()
+		proof.Flag = dup(v)
+		return nil
+	})
+	path := smt.Path(tbName, key)
+	err := m.dataDb.View(func(tx Tx) error {
+		out = getValue(tx, ltnValue, tbName, key)
+		proof.Root = smtRoot(tx)
+		b := tx.Bucket([]byte(smtNode))
+		if b == nil {
+			return nil
+		}
+		for depth := 0; depth < smt.Depth; depth++ {
+			typ, _, lp, lvh := smtGet(b, depth, path)
+			if typ == -1 {
+				return nil
+			}
+			if typ == smt.Leaf {
+				proof.LeafPath = dup(lp)
+				proof.LeafValueHash = dup(lvh)
+				return nil
+			}
+			sibling := dup(path)
+			sibling[depth/8] ^= 1 << (7 - uint(depth%8))
+			_, h, _, _ := smtGet(b, depth+1, sibling)
+			proof.Siblings = append(proof.Siblings, dup(h))
+		}
+		return fmt.Errorf("error depth of smt")
+	})
+	if err != nil {
+		log.Println("fail to get proof:", err)
+		return nil, nil, err
+	}
+	return out, proof, nil
+}
+
+// VerifyProof return true if the value(empty: not exist) of the key is proved by the proof and root
+func VerifyProof(root, tbName, key, value []byte, proof *Proof) bool {
+	return smt.Verify(root, tbName, key, value, proof)
+}
@@ -0,0 +1,114 @@
+package disk
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/lengzhao/database/smt"
+)
+
+func TestGetWithProof(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key3, value)
+	for i := 0; i < 100; i++ {
+		m.SetWithFlag(flag, tbName, []byte(fmt.Sprintf("key%d", i)), value)
+	}
+	m.Commit(flag)
+	root1, err := m.GetStateRoot(flag)
+	if err != nil {
+		t.Fatal("fail to get state root.", err)
+	}
+
+	m.OpenFlag(flag2)
+	m.SetWithFlag(flag2, tbName, key, value2)
+	m.SetWithFlag(flag2, tbName, key3, value2)
+	for i := 50; i < 100; i++ {
+		m.DeleteWithFlag(flag2, tbName, []byte(fmt.Sprintf("key%d", i)))
+	}
+	m.Commit(flag2)
+	root2, _ := m.GetStateRoot(flag2)
+	if bytes.Compare(root1, root2) == 0 {
+		t.Error("hope different root")
+	}
+
+	for _, k := range [][]byte{key, key2, key3, []byte("key1"), []byte("key60"), []byte("not exist")} {
+		v, proof, err := m.GetWithProof(tbName, k)
+		if err != nil {
+			t.Fatal("fail to get proof.", err)
+		}
+		if bytes.Compare(proof.Flag, flag2) != 0 || bytes.Compare(proof.Root, root2) != 0 {
+			t.Fatalf("error flag or root of proof:%s", proof.Flag)
+		}
+		if !VerifyProof(root2, tbName, k, v, proof) {
+			t.Errorf("fail to verify proof,key:%s,value:%s", k, v)
+		}
+		if VerifyProof(root2, tbName, k, value3, proof) {
+			t.Errorf("hope fail to verify proof with error value,key:%s", k)
+		}
+		if VerifyProof(root1, tbName, k, v, proof) && bytes.Compare(k, key2) != 0 {
+			t.Errorf("hope fail to verify proof with old root,key:%s", k)
+		}
+	}
+
+	err = m.Rollback(flag2)
+	if err != nil {
+		t.Fatal("fail to rollback.", err)
+	}
+	_, proof, _ := m.GetWithProof(tbName, key)
+	if bytes.Compare(proof.Root, root1) != 0 {
+		t.Errorf("hope the root is restored:%x,%x", proof.Root, root1)
+	}
+	if _, err = m.GetStateRoot(flag2); err == nil {
+		t.Error("hope the root of rolled back flag is removed")
+	}
+	m.Rollback(flag)
+	_, proof, _ = m.GetWithProof(tbName, key)
+	if bytes.Compare(proof.Root, smt.Empty) != 0 {
+		t.Errorf("hope empty root:%x", proof.Root)
+	}
+}
+
+func TestSmtInit(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	m.OpenFlag(flag2)
+	m.SetWithFlag(flag2, tbName, key3, value)
+	m.Commit(flag2)
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key, value)
+	m.SetWithFlag(flag, tbName, key2, value2)
+	m.DeleteWithFlag(flag, tbName, key3)
+	m.Commit(flag)
+	root, _ := m.GetStateRoot(flag)
+	// remove the tree, it is built again when open
+	m.dataDb.Update(func(tx Tx) error {
+		return tx.DeleteBucket([]byte(smtNode))
+	})
+	m.Close()
+
+	m, err = Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	_, proof, _ := m.GetWithProof(tbName, key)
+	if bytes.Compare(proof.Root, root) != 0 {
+		t.Errorf("different root after init:%x,%x", proof.Root, root)
+	}
+}
@@ -0,0 +1,359 @@
+package disk
+
+import (
+	"fmt"
+	"log"
+)
+
+// TableMode the mode of table, a table is written either with flag or without flag.
+// The mode is declared by CreateTable or the first write of the table, it is kept in data.db.
+// The mode declared by the write with flag is kept in the flag, it is undone by Cancel/Rollback.
+type TableMode byte
+
+// the modes of table
+const (
+	// TableModeNone the mode is not declared
+	TableModeNone TableMode = iota
+	// TableModeUnflagged the table is written by Set/Delete
+	TableModeUnflagged
+	// TableModeFlagged the table is written by SetWithFlag/DeleteWithFlag
+	TableModeFlagged
+)
+
+// tableMode the bucket of data.db, tbName -> mode
+const tableMode = "table_mode"
+
+func (mode TableMode) String() string {
+	switch mode {
+	case TableModeUnflagged:
+		return "unflagged"
+	case TableModeFlagged:
+		return "flagged"
+	}
+	return "none"
+}
+
+// TableModeError the error of writing the table in the other mode
+type TableModeError struct {
+	TbName []byte
+	// Mode the mode of the table
+	Mode TableMode
+}
+
+// TableModeErrorPrefix the prefix of the message of TableModeError, it is kept for the rpc client
+const TableModeErrorPrefix = "wrong table mode"
+
+func (e *TableModeError) Error() string {
+	return fmt.Sprintf("%s,the table %s is %s", TableModeErrorPrefix, e.TbName, e.Mode)
+}
+
+// TableStat the statistics of the committed data of a table
+type TableStat struct {
+	Mode TableMode
+	// Keys the number of keys
+	Keys uint64
+	// Bytes the total size of the keys and values
+	Bytes uint64
+	// Flagged true if the table has data written with flag, it can only be dropped with flag
+	Flagged bool
+}
+
+// ListTables return the tables which have data in data.db, in the order of name.
+// The data of the opened flags is not included, a table without keys(or only with expired keys) is not listed.
+func (m *Manager) ListTables() [][]byte {
+	var out [][]byte
+	now := ttlNow().UnixNano()
+	m.dataDb.View(func(tx Tx) error {
+		c := tx.Cursor()
+		for name, _ := c.Seek([]byte{ltnValue}); name != nil && name[0] == ltnValue; name, _ = c.Next() {
+			b := tx.Bucket(name)
+			if b == nil {
+				continue
+			}
+			eb := tx.Bucket(getLocalTableName(ltnExpire, name[1:]))
+			bc := b.Cursor()
+			k, _ := bc.First()
+			for k != nil && isExpired(eb, k, now) {
+				k, _ = bc.Next()
+			}
+			if k == nil {
+				continue
+			}
+			out = append(out, dup(name[1:]))
+		}
+		return nil
+	})
+	return out
+}
+
+// TableStats return the statistics of the committed data of the table, the expired keys are not included
+func (m *Manager) TableStats(tbName []byte) TableStat {
+	var out TableStat
+	now := ttlNow().UnixNano()
+	m.dataDb.View(func(tx Tx) error {
+		if b := tx.Bucket(getLocalTableName(ltnValue, tbName)); b != nil {
+			eb := tx.Bucket(getLocalTableName(ltnExpire, tbName))
+			b.ForEach(func(k, v []byte) error {
+				if isExpired(eb, k, now) {
+					return nil
+				}
+				out.Keys++
+				out.Bytes += uint64(len(k) + len(v))
+				return nil
+			})
+		}
+		out.Flagged = isFlagged(tx, tbName)
+		out.Mode = getMode(tx, tbName)
+		return nil
+	})
+	return out
+}
+
+// getMode return the mode of the table. The mode of the table written before the mode is supported
+// is got from its data.
+func getMode(tx Tx, tbName []byte) TableMode {
+	v := tx.Bucket([]byte(tableMode)).Get(getLocalTableName(ltnValue, tbName))
+	if len(v) == 1 {
+		return TableMode(v[0])
+	}
+	if isFlagged(tx, tbName) {
+		return TableModeFlagged
+	}
+	if b := tx.Bucket(getLocalTableName(ltnValue, tbName)); b != nil {
+		if k, _ := b.Cursor().First(); k != nil {
+			return TableModeUnflagged
+		}
+	}
+	return TableModeNone
+}
+
+// flagTable return true if an opened flag writes the table. m.mu must be held.
+func (m *Manager) flagTable(tbName []byte) bool {
+	for _, fs := range m.flags {
+		if fs.tables[string(tbName)] {
+			return true
+		}
+	}
+	return false
+}
+
+// currentMode return the mode of the table(include the mode declared by the opened flags). m.mu must be held.
+// tx=nil: read the mode in a new transaction. The mode read in tx is not cached, tx may be not committed.
+func (m *Manager) currentMode(tx Tx, tbName []byte) TableMode {
+	cur, ok := m.modes[string(tbName)]
+	if !ok && tx != nil {
+		cur = getMode(tx, tbName)
+	} else if !ok {
+		m.dataDb.View(func(tx Tx) error {
+			cur = getMode(tx, tbName)
+			return nil
+		})
+		if cur != TableModeNone {
+			m.modes[string(tbName)] = cur
+		}
+	}
+	if cur == TableModeNone && m.flagTable(tbName) {
+		cur = TableModeFlagged
+	}
+	return cur
+}
+
+// updateUnflagged check the mode of the tables and run fn in the same transaction of data.db.
+// The mode of the table is declared in the transaction if not declared, so nothing is written if fail.
+// m.mu must be held.
+func (m *Manager) updateUnflagged(tables [][]byte, fn func(tx Tx) error) error {
+	if m.failed != nil {
+		return m.failed
+	}
+	for _, tbName := range tables {
+		if m.modes[string(tbName)] == TableModeFlagged || m.flagTable(tbName) {
+			log.Printf("wrong table mode,table:%s,mode:%s,write:%s\n", tbName, TableModeFlagged, TableModeUnflagged)
+			return &TableModeError{dup(tbName), TableModeFlagged}
+		}
+	}
+	err := m.dataDb.Update(func(tx Tx) error {
+		for _, tbName := range tables {
+			cur := m.currentMode(tx, tbName)
+			if cur == TableModeUnflagged {
+				continue
+			}
+			if cur != TableModeNone {
+				log.Printf("wrong table mode,table:%s,mode:%s,write:%s\n", tbName, cur, TableModeUnflagged)
+				return &TableModeError{dup(tbName), cur}
+			}
+			err := tx.Bucket([]byte(tableMode)).Put(getLocalTableName(ltnValue, tbName), []byte{byte(TableModeUnflagged)})
+			if err != nil {
+				log.Printf("fail to declare the mode of table:%s,%s\n", tbName, err)
+				return err
+			}
+		}
+		return fn(tx)
+	})
+	if err != nil {
+		return err
+	}
+	for _, tbName := range tables {
+		m.modes[string(tbName)] = TableModeUnflagged
+	}
+	return nil
+}
+
+// useFlagMode check the flag and the mode of the tables before writing with the flag, m.mu must be held.
+// The flag which has opened child flags can not be written, the child flags read its data.
+// The mode is declared in the flag, it is dropped if the flag is canceled.
+// After the flag is committed, the mode is got from the data of the flag(see getMode), so it is undone by rollback.
+func (m *Manager) useFlagMode(fs *flagState, tables ...[]byte) error {
+	if m.failed != nil {
+		return m.failed
+	}
+	if m.hasChild(fs) {
+		log.Printf("try to write the flag which has child flags:%x\n", fs.flag)
+		return fmt.Errorf("exist child flag")
+	}
+	for _, tbName := range tables {
+		cur := m.currentMode(nil, tbName)
+		if cur != TableModeNone && cur != TableModeFlagged {
+			log.Printf("wrong table mode,table:%s,mode:%s,write:%s\n", tbName, cur, TableModeFlagged)
+			return &TableModeError{dup(tbName), cur}
+		}
+	}
+	for _, tbName := range tables {
+		fs.tables[string(tbName)] = true
+	}
+	return nil
+}
+
+// CreateTable declare the mode of the table, return TableModeError if it has been declared as another mode.
+// The declaration is kept in data.db, it is not undone by rollback.
+func (m *Manager) CreateTable(tbName []byte, mode TableMode) error {
+	if mode != TableModeUnflagged && mode != TableModeFlagged {
+		return fmt.Errorf("unknown table mode")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failed != nil {
+		return m.failed
+	}
+	if mode == TableModeUnflagged {
+		return m.updateUnflagged([][]byte{tbName}, func(tx Tx) error {
+			return nil
+		})
+	}
+	err := m.dataDb.Update(func(tx Tx) error {
+		cur := m.currentMode(tx, tbName)
+		if cur == TableModeUnflagged {
+			log.Printf("wrong table mode,table:%s,mode:%s,write:%s\n", tbName, cur, mode)
+			return &TableModeError{dup(tbName), cur}
+		}
+		return tx.Bucket([]byte(tableMode)).Put(getLocalTableName(ltnValue, tbName), []byte{byte(mode)})
+	})
+	if err == nil {
+		m.modes[string(tbName)] = mode
+	}
+	return err
+}
+
+// GetTableMode return the mode of the table(include the mode declared by the opened flags),
+// TableModeNone if not declared
+func (m *Manager) GetTableMode(tbName []byte) TableMode {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.currentMode(nil, tbName)
+}
+
+// dropEmptyTable remove the table dropped with flag and its mode, if the table has no key after the commit
+func dropEmptyTable(tx Tx, tbName []byte) error {
+	name := getLocalTableName(ltnValue, tbName)
+	if b := tx.Bucket(name); b != nil {
+		if k, _ := b.Cursor().First(); k != nil {
+			return nil
+		}
+		err := tx.DeleteBucket(name)
+		if err != nil {
+			return err
+		}
+	}
+	if fn := getLocalTableName(ltnFlag, tbName); tx.Bucket(fn) != nil {
+		err := tx.DeleteBucket(fn)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Bucket([]byte(tableMode)).Delete(name)
+}
+
+// isFlagged return true if the table has data written with flag
+func isFlagged(tx Tx, tbName []byte) bool {
+	b := tx.Bucket(getLocalTableName(ltnFlag, tbName))
+	if b == nil {
+		return false
+	}
+	k, _ := b.Cursor().First()
+	return k != nil
+}
+
+// DropTable remove the table(and its mode) from data.db, unable rollback.
+// The flagged table(see TableMode) must be dropped by DropTableWithFlag.
+func (m *Manager) DropTable(tbName []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failed != nil {
+		return m.failed
+	}
+	err := m.dataDb.Update(func(tx Tx) error {
+		if m.currentMode(tx, tbName) == TableModeFlagged || isFlagged(tx, tbName) {
+			log.Printf("try to drop the table with flag data:%s\n", tbName)
+			return &TableModeError{dup(tbName), TableModeFlagged}
+		}
+		name := getLocalTableName(ltnValue, tbName)
+		err := tx.Bucket([]byte(tableMode)).Delete(name)
+		if err != nil {
+			return err
+		}
+		// the index of the ttl is removed by the reaper
+		if en := getLocalTableName(ltnExpire, tbName); tx.Bucket(en) != nil {
+			err = tx.DeleteBucket(en)
+			if err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(name) == nil {
+			return err
+		}
+		return tx.DeleteBucket(name)
+	})
+	if err == nil {
+		delete(m.modes, string(tbName))
+	}
+	return err
+}
+
+// DropTableWithFlag delete all keys of the table with flag(include the data of the flag and its parents),
+// enable rollback. The keys set after it are kept.
+// When the flag is committed, the table(and its mode) is removed from data.db if it has no key,
+// then it can be declared as another mode. Rollback restores the keys and the mode.
+func (m *Manager) DropTableWithFlag(flag, tbName []byte) error {
+	m.mu.Lock()
+	fs := m.getFlag(flag)
+	m.mu.Unlock()
+	if fs == nil {
+		log.Printf("DropTable:not open flag:%x\n", flag)
+		return fmt.Errorf("not open flag")
+	}
+	items, _ := m.scan(fs, tbName, nil, nil, 0, false)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.getFlag(flag) != fs {
+		return fmt.Errorf("not open flag")
+	}
+	err := m.useFlagMode(fs, tbName)
+	if err != nil {
+		return err
+	}
+	for _, it := range items {
+		fs.set(tbName, it.Key, nil)
+	}
+	fs.dropped[string(tbName)] = true
+	log.Printf("drop table with flag:%x,table:%s,keys:%d\n", flag, tbName, len(items))
+	return nil
+}
@@ -0,0 +1,284 @@
+package disk
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestTables(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	tbName2 := []byte("tbName2")
+	m.Set(tbName2, key, value)
+	m.Set(tbName2, key2, value2)
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key, value)
+	m.Commit(flag)
+	m.Set(tbName2, key3, value3)
+	m.Delete(tbName2, key3)
+
+	tables := m.ListTables()
+	if len(tables) != 2 || bytes.Compare(tables[0], tbName) != 0 || bytes.Compare(tables[1], tbName2) != 0 {
+		t.Errorf("error tables:%s", tables)
+	}
+	st := m.TableStats(tbName2)
+	if st.Keys != 2 || st.Bytes != uint64(len(key)+len(value)+len(key2)+len(value2)) || st.Flagged {
+		t.Error("error stats:", st)
+	}
+	st = m.TableStats(tbName)
+	if st.Keys != 1 || !st.Flagged {
+		t.Error("error stats:", st)
+	}
+	if st = m.TableStats([]byte("not exist")); st.Keys != 0 || st.Flagged {
+		t.Error("error stats of the table not exist:", st)
+	}
+
+	err = m.DropTable(tbName)
+	if err == nil {
+		t.Error("hope error when drop the table with flag data without flag")
+	}
+	err = m.DropTable(tbName2)
+	if err != nil {
+		t.Fatal("fail to drop table:", err)
+	}
+	if m.Exist(tbName2, key) || len(m.ListTables()) != 1 {
+		t.Error("hope the table dropped")
+	}
+}
+
+func TestDropTableWithFlag(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key, value)
+	m.SetWithFlag(flag, tbName, key2, value2)
+	m.Commit(flag)
+	root, _ := m.GetStateRoot(flag)
+
+	m.OpenFlag(flag2)
+	m.SetWithFlag(flag2, tbName, key3, value3)
+	err = m.DropTableWithFlag(flag2, tbName)
+	if err != nil {
+		t.Fatal("fail to drop table:", err)
+	}
+	m.SetWithFlag(flag2, tbName, key2, value3)
+	if m.Exist(tbName, key) || m.Exist(tbName, key3) || !m.Exist(tbName, key2) {
+		t.Error("hope the keys deleted with flag")
+	}
+	m.Commit(flag2)
+	if m.Exist(tbName, key) || m.Exist(tbName, key3) {
+		t.Error("hope the keys deleted after commit")
+	}
+	if st := m.TableStats(tbName); st.Keys != 1 {
+		t.Error("error stats:", st)
+	}
+
+	err = m.Rollback(flag2)
+	if err != nil {
+		t.Fatal("fail to rollback:", err)
+	}
+	v := m.Get(tbName, key)
+	v2 := m.Get(tbName, key2)
+	if bytes.Compare(v, value) != 0 || bytes.Compare(v2, value2) != 0 || m.Exist(tbName, key3) {
+		t.Errorf("different value after rollback,get:%s,%s", v, v2)
+	}
+	root2, _ := m.GetStateRoot(flag)
+	v, proof, _ := m.GetWithProof(tbName, key)
+	if bytes.Compare(root, root2) != 0 || !VerifyProof(root, tbName, key, v, proof) {
+		t.Error("different state root after rollback")
+	}
+	if m.DropTableWithFlag(flag2, tbName) == nil {
+		t.Error("hope error of the flag not opened")
+	}
+}
+
+func TestTableMode(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	tbName2 := []byte("tbName2")
+	err = m.CreateTable(tbName2, TableModeFlagged)
+	if err != nil {
+		t.Fatal("fail to create table:", err)
+	}
+	err = m.Set(tbName2, key, value)
+	if me, ok := err.(*TableModeError); !ok || me.Mode != TableModeFlagged {
+		t.Error("hope table mode error:", err)
+	}
+	m.Set(tbName, key, value)
+	m.OpenFlag(flag)
+	err = m.SetWithFlag(flag, tbName, key, value2)
+	if me, ok := err.(*TableModeError); !ok || me.Mode != TableModeUnflagged {
+		t.Error("hope table mode error:", err)
+	}
+	if m.DeleteWithFlag(flag, tbName, key) == nil || m.DropTableWithFlag(flag, tbName) == nil {
+		t.Error("hope error when delete the unflagged table with flag")
+	}
+	if m.CreateTable(tbName, TableModeFlagged) == nil {
+		t.Error("hope error when create the table with another mode")
+	}
+	m.SetWithFlag(flag, tbName2, key, value2)
+	m.Commit(flag)
+	if m.Delete(tbName2, key) == nil || m.DropTable(tbName2) == nil {
+		t.Error("hope error when delete the flagged table without flag")
+	}
+	// the table written before the mode is supported
+	m.dataDb.Update(func(tx Tx) error {
+		return tx.Bucket([]byte(tableMode)).Delete(getLocalTableName(ltnValue, tbName2))
+	})
+	m.Close()
+
+	m, err = Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	if mode := m.GetTableMode(tbName); mode != TableModeUnflagged {
+		t.Error("error mode:", mode)
+	}
+	if st := m.TableStats(tbName2); st.Mode != TableModeFlagged {
+		t.Error("error mode of the old table:", st.Mode)
+	}
+	if m.Set(tbName2, key, value) == nil {
+		t.Error("hope error when write the old flagged table without flag")
+	}
+	err = m.DropTable(tbName)
+	if err != nil {
+		t.Fatal("fail to drop table:", err)
+	}
+	if mode := m.GetTableMode(tbName); mode != TableModeNone {
+		t.Error("hope the mode is removed with the table:", mode)
+	}
+}
+
+func TestFlagTableMode(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	tbName2 := []byte("tbName2")
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key, value)
+	m.DropTableWithFlag(flag, tbName2)
+	if mode := m.GetTableMode(tbName2); mode != TableModeFlagged {
+		t.Error("hope the table is flagged in the opened flag:", mode)
+	}
+	if _, ok := m.Set(tbName, key, value).(*TableModeError); !ok {
+		t.Error("hope table mode error when write the table of the opened flag without flag")
+	}
+	// the mode declared by the canceled flag is undone
+	m.Cancel(flag)
+	if mode := m.GetTableMode(tbName2); mode != TableModeNone {
+		t.Error("hope the mode is undone by cancel:", mode)
+	}
+	m.Set(tbName2, key, value)
+
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key, value)
+	m.Commit(flag)
+	if mode := m.GetTableMode(tbName); mode != TableModeFlagged {
+		t.Error("hope flagged after commit:", mode)
+	}
+	m.Close()
+	m, err = Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	if mode := m.GetTableMode(tbName); mode != TableModeFlagged {
+		t.Error("hope flagged after reopen:", mode)
+	}
+	err = m.Rollback(flag)
+	if err != nil {
+		t.Fatal("fail to rollback.", err)
+	}
+	if mode := m.GetTableMode(tbName); mode != TableModeNone {
+		t.Error("hope the mode is undone by rollback:", mode)
+	}
+	err = m.Set(tbName, key, value2)
+	if err != nil {
+		t.Error("fail to write the table without flag after rollback:", err)
+	}
+}
+
+func TestDropFlaggedTable(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	m, err := Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	tbName2 := []byte("tbName2")
+	m.CreateTable(tbName, TableModeFlagged)
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, tbName, key, value)
+	m.SetWithFlag(flag, tbName2, key, value)
+	m.Commit(flag)
+
+	m.OpenFlag(flag2)
+	m.DropTableWithFlag(flag2, tbName)
+	m.DropTableWithFlag(flag2, tbName2)
+	// the table is written after drop, it is kept
+	m.SetWithFlag(flag2, tbName2, key2, value2)
+	err = m.Commit(flag2)
+	if err != nil {
+		t.Fatal("fail to commit.", err)
+	}
+	m.Close()
+	m, err = Open(testDir, nil)
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	if mode := m.GetTableMode(tbName); mode != TableModeNone {
+		t.Error("hope the dropped table is removed:", mode)
+	}
+	if tables := m.ListTables(); len(tables) != 1 || bytes.Compare(tables[0], tbName2) != 0 {
+		t.Errorf("error tables:%s", tables)
+	}
+	if st := m.TableStats(tbName2); st.Mode != TableModeFlagged || st.Keys != 1 {
+		t.Error("hope the table written after drop is kept:", st)
+	}
+
+	err = m.Rollback(flag2)
+	if err != nil {
+		t.Fatal("fail to rollback.", err)
+	}
+	if mode := m.GetTableMode(tbName); mode != TableModeFlagged || bytes.Compare(m.Get(tbName, key), value) != 0 {
+		t.Error("hope the table and its mode are restored:", mode)
+	}
+	if m.Set(tbName, key, value2) == nil {
+		t.Error("hope error when write the restored table without flag")
+	}
+
+	// the table can be declared as another mode after the drop is committed
+	m.OpenFlag(flag2)
+	m.DropTableWithFlag(flag2, tbName)
+	m.Commit(flag2)
+	err = m.Set(tbName, key, value2)
+	if err != nil {
+		t.Error("fail to write the dropped table without flag.", err)
+	}
+}
@@ -0,0 +1,215 @@
+package disk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"time"
+)
+
+// expireIndex the bucket of data.db, expire time(unix nano)+len(tbName)+tbName+key -> nil, used by the reaper
+const expireIndex = "expire_index"
+
+// DefaultReapInterval the default interval of deleting the expired keys
+const DefaultReapInterval = time.Second
+
+var (
+	// reapBatch the max number of the expired keys deleted in one transaction
+	reapBatch = 1000
+	// ttlNow the clock of the ttl
+	ttlNow = time.Now
+)
+
+// SetWithTTL set data which expires after ttl, unable rollback. Only for the table without flag.
+// The expired key is same as not exist, it is deleted by the reaper in background.
+// Set/Delete the key again remove the ttl.
+func (m *Manager) SetWithTTL(tbName, key, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("invalid ttl:%s", ttl)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	at := ttlNow().Add(ttl).UnixNano()
+	return m.updateUnflagged([][]byte{tbName}, func(tx Tx) error {
+		b, err := tx.CreateBucketIfNotExists(getLocalTableName(ltnValue, tbName))
+		if err != nil {
+			log.Printf("fail to create bucket,%s\n", tbName)
+			return err
+		}
+		err = clearExpire(tx, tbName, key)
+		if err != nil {
+			return err
+		}
+		err = putOrDelete(b, key, value)
+		if err != nil {
+			log.Println("fail to put:", key, err)
+			return err
+		}
+		if len(value) == 0 {
+			return nil
+		}
+		eb, err := tx.CreateBucketIfNotExists(getLocalTableName(ltnExpire, tbName))
+		if err != nil {
+			log.Printf("fail to create expire bucket,%s\n", tbName)
+			return err
+		}
+		err = eb.Put(dup(key), itoa(uint64(at)))
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(expireIndex)).Put(expireIndexKey(at, tbName, key), []byte{})
+	})
+}
+
+func expireIndexKey(at int64, tbName, key []byte) []byte {
+	out := make([]byte, 8+binary.MaxVarintLen64, 8+binary.MaxVarintLen64+len(tbName)+len(key))
+	binary.BigEndian.PutUint64(out, uint64(at))
+	n := binary.PutUvarint(out[8:], uint64(len(tbName)))
+	out = append(out[:8+n], tbName...)
+	return append(out, key...)
+}
+
+func parseExpireIndexKey(k []byte) (at int64, tbName, key []byte, err error) {
+	if len(k) < 9 {
+		return 0, nil, nil, fmt.Errorf("error expire index:%x", k)
+	}
+	l, n := binary.Uvarint(k[8:])
+	if n <= 0 || uint64(len(k)-8-n) < l {
+		return 0, nil, nil, fmt.Errorf("error expire index:%x", k)
+	}
+	tbName = k[8+n : 8+n+int(l)]
+	return int64(atoi(k[:8])), tbName, k[8+n+int(l):], nil
+}
+
+// isExpired return true if the key of the expire bucket expired
+func isExpired(eb Bucket, key []byte, now int64) bool {
+	if eb == nil {
+		return false
+	}
+	v := eb.Get(key)
+	return len(v) == 8 && int64(atoi(v)) <= now
+}
+
+// getLiveValue get the value of the table without flag, return nil if not exist or expired
+func getLiveValue(tx Tx, tbName, key []byte) []byte {
+	if isExpired(tx.Bucket(getLocalTableName(ltnExpire, tbName)), key, ttlNow().UnixNano()) {
+		return nil
+	}
+	return getValue(tx, ltnValue, tbName, key)
+}
+
+// clearExpire remove the ttl of the key, it is called before writing the key
+func clearExpire(tx Tx, tbName, key []byte) error {
+	eb := tx.Bucket(getLocalTableName(ltnExpire, tbName))
+	if eb == nil {
+		return nil
+	}
+	v := eb.Get(key)
+	if len(v) != 8 {
+		return nil
+	}
+	err := tx.Bucket([]byte(expireIndex)).Delete(expireIndexKey(int64(atoi(v)), tbName, key))
+	if err != nil {
+		return err
+	}
+	return eb.Delete(key)
+}
+
+// nextExpire return the earliest expire time(unix nano) in the index, ok=false if no key has ttl
+func (m *Manager) nextExpire() (at int64, ok bool) {
+	m.dataDb.View(func(tx Tx) error {
+		k, _ := tx.Bucket([]byte(expireIndex)).Cursor().First()
+		if len(k) >= 8 {
+			at, ok = int64(atoi(k[:8])), true
+		}
+		return nil
+	})
+	return
+}
+
+// reapExpired delete at most reapBatch keys expired before now, return the number of the deleted index.
+// Nothing is written if no key expired.
+func (m *Manager) reapExpired(now time.Time) (int, error) {
+	if at, ok := m.nextExpire(); !ok || at > now.UnixNano() {
+		return 0, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.dataDb == nil {
+		return 0, nil
+	}
+	if m.failed != nil {
+		return 0, m.failed
+	}
+	var count int
+	err := m.dataDb.Update(func(tx Tx) error {
+		ib := tx.Bucket([]byte(expireIndex))
+		var keys [][]byte
+		c := ib.Cursor()
+		for k, _ := c.First(); k != nil && len(keys) < reapBatch; k, _ = c.Next() {
+			if len(k) >= 8 && int64(atoi(k[:8])) > now.UnixNano() {
+				break
+			}
+			keys = append(keys, dup(k))
+		}
+		for _, k := range keys {
+			err := ib.Delete(k)
+			if err != nil {
+				return err
+			}
+			count++
+			at, tbName, key, err := parseExpireIndexKey(k)
+			if err != nil {
+				log.Println("skip the error expire index.", err)
+				continue
+			}
+			eb := tx.Bucket(getLocalTableName(ltnExpire, tbName))
+			// the ttl is removed or changed
+			if eb == nil || bytes.Compare(eb.Get(key), itoa(uint64(at))) != 0 {
+				continue
+			}
+			err = eb.Delete(key)
+			if err != nil {
+				return err
+			}
+			if b := tx.Bucket(getLocalTableName(ltnValue, tbName)); b != nil {
+				err = b.Delete(key)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("fail to reap the expired keys.", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// reap delete the expired keys every ReapInterval until the manager is closed
+func (m *Manager) reap(done chan struct{}) {
+	defer m.wg.Done()
+	t := time.NewTicker(m.opt.ReapInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		for {
+			n, err := m.reapExpired(ttlNow())
+			if err != nil || n < reapBatch {
+				break
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}
+}
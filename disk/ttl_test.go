@@ -0,0 +1,121 @@
+package disk
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSetWithTTL(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	now := time.Now()
+	defer func(fn func() time.Time) { ttlNow = fn }(ttlNow)
+	ttlNow = func() time.Time { return now }
+	m, err := Open(testDir, &Options{ReapInterval: time.Hour})
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	if m.SetWithTTL(tbName, key, value, 0) == nil {
+		t.Error("hope error with invalid ttl")
+	}
+	err = m.SetWithTTL(tbName, key, value, time.Second)
+	if err != nil {
+		t.Fatal("fail to set with ttl.", err)
+	}
+	m.SetWithTTL(tbName, key2, value2, time.Second)
+	m.SetWithTTL(tbName, key3, value3, time.Hour)
+	// Set remove the ttl
+	m.Set(tbName, key2, value2)
+	if bytes.Compare(m.Get(tbName, key), value) != 0 {
+		t.Error("hope exist before expired")
+	}
+	now = now.Add(time.Second)
+	if m.Get(tbName, key) != nil || m.Exist(tbName, key) {
+		t.Error("hope the expired key not exist")
+	}
+	if k := m.GetNextKey(tbName, nil); bytes.Compare(k, key2) != 0 {
+		t.Errorf("hope skip the expired key:%s", k)
+	}
+	if !m.Exist(tbName, key2) || !m.Exist(tbName, key3) {
+		t.Error("hope the keys without ttl or not expired exist")
+	}
+	if st := m.TableStats(tbName); st.Keys != 2 {
+		t.Error("hope the expired key is not counted:", st.Keys)
+	}
+	if ok, _ := m.SetIfAbsent(tbName, key, value2); !ok {
+		t.Error("hope the expired key is absent")
+	}
+	m.SetWithTTL(tbName, key, value, time.Second)
+
+	if n, _ := m.reapExpired(now); n != 0 {
+		t.Error("hope nothing is reaped before expired:", n)
+	}
+	if n, _ := m.reapExpired(now.Add(time.Second)); n != 1 {
+		t.Error("error number of reaped keys:", n)
+	}
+	if n, _ := m.reapExpired(now.Add(2 * time.Hour)); n != 1 {
+		t.Error("error number of reaped keys:", n)
+	}
+	now = now.Add(2 * time.Hour)
+	if st := m.TableStats(tbName); st.Keys != 1 || m.Exist(tbName, key3) || !m.Exist(tbName, key2) {
+		t.Error("error keys after reap:", st.Keys)
+	}
+
+	tb2 := []byte("ttlTable")
+	m.SetWithTTL(tb2, key, value, time.Second)
+	if len(m.ListTables()) != 2 {
+		t.Error("error tables:", m.ListTables())
+	}
+	now = now.Add(time.Second)
+	if tables := m.ListTables(); len(tables) != 1 || bytes.Compare(tables[0], tbName) != 0 {
+		t.Errorf("hope the table only with expired keys is not listed:%s", tables)
+	}
+
+	flagTable := []byte("flagTable")
+	m.OpenFlag(flag)
+	m.SetWithFlag(flag, flagTable, key, value)
+	if m.SetWithTTL(flagTable, key, value, time.Hour) == nil {
+		t.Error("hope error on flagged table")
+	}
+}
+
+func TestReapBatch(t *testing.T) {
+	log.Println("start test:", t.Name())
+	defer os.RemoveAll(testDir)
+	os.RemoveAll(testDir)
+	defer func(n int) { reapBatch = n }(reapBatch)
+	reapBatch = 3
+	m, err := Open(testDir, &Options{ReapInterval: time.Hour})
+	if err != nil {
+		t.Fatal("fail to open dir", err)
+	}
+	defer m.Close()
+	for i := 0; i < 10; i++ {
+		m.SetWithTTL(tbName, []byte{byte(i)}, value, time.Minute)
+	}
+	// the ttl is changed, the old index is removed
+	m.SetWithTTL(tbName, []byte{0}, value, time.Hour)
+	now := time.Now().Add(time.Minute)
+	var counts []int
+	for {
+		n, err := m.reapExpired(now)
+		if err != nil {
+			t.Fatal("fail to reap.", err)
+		}
+		if n == 0 {
+			break
+		}
+		counts = append(counts, n)
+	}
+	if len(counts) != 3 || counts[0] != 3 || counts[2] != 3 {
+		t.Error("hope delete the expired keys in batches:", counts)
+	}
+	if st := m.TableStats(tbName); st.Keys != 1 || !m.Exist(tbName, []byte{0}) {
+		t.Error("error keys after reap:", st.Keys)
+	}
+}
@@ -35,6 +35,29 @@ type Config struct {
 	ReadTimeout  time.Duration `json:"read_timeout,omitempty"`
 	WriteTimeout time.Duration `json:"write_timeout,omitempty"`
 	IdleTimeout  time.Duration `json:"idle_timeout,omitempty"`
+	// Chains the config of chains, key: chain id, "default": the chains not in the map
+	Chains map[string]ChainConfig `json:"chains,omitempty"`
+}
+
+// ChainConfig the storage engine and history retention of chain, 0: the default value of disk.Options
+type ChainConfig struct {
+	Engine       string        `json:"engine,omitempty"`
+	HistoryMax   uint64        `json:"history_max,omitempty"`
+	HistoryBytes int64         `json:"history_bytes,omitempty"`
+	HistoryAge   time.Duration `json:"history_age,omitempty"`
+}
+
+func (c Config) chainOptions(id uint64) *disk.Options {
+	cc, ok := c.Chains[fmt.Sprintf("%d", id)]
+	if !ok {
+		cc = c.Chains["default"]
+	}
+	return &disk.Options{
+		Engine:       cc.Engine,
+		HistoryMax:   cc.HistoryMax,
+		HistoryBytes: cc.HistoryBytes,
+		HistoryAge:   cc.HistoryAge * time.Second,
+	}
 }
 
 func getDir() string {
@@ -84,7 +107,7 @@ func (p *program) run() {
 	dbDir := path.Join(wd, "db_dir")
 	db := server.NewRPCObj(dbDir)
 	server.RegisterAPI(db, func(dir string, id uint64) server.DBApi {
-		m, err := disk.Open(dir)
+		m, err := disk.Open(dir, c.chainOptions(id))
 		if err != nil {
 			log.Println("fail to open db manager,dir:", dir, err)
 			return nil
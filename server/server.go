@@ -1,10 +1,14 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
 	"sync"
+	"time"
+
+	"github.com/lengzhao/database/disk"
 )
 
 // TDb rpc接口
@@ -15,6 +19,8 @@ type TDb struct {
 	factory DBFactory
 }
 
+var errPageFull = errors.New("page full")
+
 // SetArgs Set接口的入参
 type SetArgs struct {
 	Chain  uint64
@@ -32,6 +38,14 @@ type SetWithFlagArgs struct {
 	Value  []byte
 }
 
+// DeleteWithFlagArgs DeleteWithFlag接口的入参
+type DeleteWithFlagArgs struct {
+	Chain  uint64
+	Flag   []byte
+	TbName []byte
+	Key    []byte
+}
+
 // GetArgs Get接口的入参
 type GetArgs struct {
 	Chain  uint64
@@ -45,19 +59,282 @@ type FlagArgs struct {
 	Flag  []byte
 }
 
+// OpenFlagOnArgs OpenFlagOn接口的入参
+type OpenFlagOnArgs struct {
+	Chain  uint64
+	Parent []byte
+	Flag   []byte
+}
+
+// GetWithFlagArgs GetWithFlag接口的入参
+type GetWithFlagArgs struct {
+	Chain  uint64
+	Flag   []byte
+	TbName []byte
+	Key    []byte
+}
+
+// SavepointArgs savepoint操作的参数
+type SavepointArgs struct {
+	Chain uint64
+	Flag  []byte
+	ID    uint64
+}
+
+// ScanArgs Scan接口的入参
+type ScanArgs struct {
+	Chain  uint64
+	TbName []byte
+	Start  []byte
+	End    []byte
+	Limit  int
+	// Reverse scan in the descending order of key
+	Reverse bool
+	// Flag scan in the view of the opened flag if not empty
+	Flag []byte
+}
+
+// ScanPrefixArgs ScanPrefix接口的入参
+type ScanPrefixArgs struct {
+	Chain  uint64
+	TbName []byte
+	Prefix []byte
+	Limit  int
+}
+
+// KV key and value
+type KV struct {
+	Key   []byte
+	Value []byte
+}
+
+// ScanReply Scan接口的返回值
+type ScanReply struct {
+	Items []KV
+	Next  []byte
+}
+
+// FlagChangesArgs GetFlagChanges接口的入参
+type FlagChangesArgs struct {
+	Chain  uint64
+	Flag   []byte
+	Offset int
+	Limit  int
+}
+
+// Change 标志修改的数据，空值表示key不存在
+type Change struct {
+	TbName   []byte
+	Key      []byte
+	PreValue []byte
+	Value    []byte
+}
+
+// FlagChangesReply GetFlagChanges接口的返回值
+type FlagChangesReply struct {
+	Items []Change
+	Next  int
+}
+
+// ChangesBetweenArgs ChangesBetween接口的入参
+type ChangesBetweenArgs struct {
+	Chain    uint64
+	FromFlag []byte
+	ToFlag   []byte
+	// Start the key of the first change, it is the Next of the previous page. nil: from the first change
+	Start *TableKey
+	Limit int
+}
+
+// ChangesBetweenReply ChangesBetween接口的返回值
+type ChangesBetweenReply struct {
+	Items []Change
+	// Next the key of the first change of the next page, nil: no more changes
+	Next *TableKey
+}
+
+// Proof 数据的证明
+type Proof struct {
+	Flag          []byte
+	Root          []byte
+	Siblings      [][]byte
+	LeafPath      []byte
+	LeafValueHash []byte
+}
+
+// ProofReply GetWithProof接口的返回值
+type ProofReply struct {
+	Value []byte
+	Proof Proof
+}
+
+// VerifyChainReply VerifyChain接口的返回值
+type VerifyChainReply struct {
+	// Checked the number of checked flags
+	Checked int
+	// Mismatch the first flag whose commit hash is different, nil: all right
+	Mismatch []byte
+}
+
+// TableMode 表的模式，取值同disk.TableMode
+type TableMode byte
+
+// CreateTableArgs CreateTable接口的入参
+type CreateTableArgs struct {
+	Chain  uint64
+	TbName []byte
+	Mode   TableMode
+}
+
+// TableStat 表的统计信息(已提交的数据)
+type TableStat struct {
+	Mode    TableMode
+	Keys    uint64
+	Bytes   uint64
+	Flagged bool
+}
+
+// BatchItem 批量写入的数据，value为空时删除数据
+type BatchItem struct {
+	TbName []byte
+	Key    []byte
+	Value  []byte
+}
+
+// SetBatchArgs SetBatch接口的入参
+type SetBatchArgs struct {
+	Chain uint64
+	// Flag SetWithFlagBatch的标志
+	Flag  []byte
+	Items []BatchItem
+}
+
+// SetWithTTLArgs SetWithTTL接口的入参
+type SetWithTTLArgs struct {
+	Chain  uint64
+	TbName []byte
+	Key    []byte
+	Value  []byte
+	TTL    time.Duration
+}
+
+// CompareAndSetArgs CompareAndSet接口的入参
+type CompareAndSetArgs struct {
+	Chain    uint64
+	TbName   []byte
+	Key      []byte
+	Expected []byte
+	Value    []byte
+}
+
+// MergeArgs Merge接口的入参
+type MergeArgs struct {
+	Chain uint64
+	// Flag MergeWithFlag的标志
+	Flag    []byte
+	TbName  []byte
+	Key     []byte
+	Op      string
+	Operand []byte
+}
+
+// IncrementArgs Increment接口的入参
+type IncrementArgs struct {
+	Chain uint64
+	// Flag IncrementWithFlag的标志
+	Flag   []byte
+	TbName []byte
+	Key    []byte
+	Delta  int64
+}
+
+// TableKey 表中的key
+type TableKey struct {
+	TbName []byte
+	Key    []byte
+}
+
+// GetMultiArgs GetMulti接口的入参
+type GetMultiArgs struct {
+	Chain uint64
+	Keys  []TableKey
+}
+
+// GetMultiReply GetMulti接口的返回值
+type GetMultiReply struct {
+	Values [][]byte
+	Found  []bool
+}
+
+// RecoveryReport 数据库打开时的恢复结果
+type RecoveryReport struct {
+	Journal       string
+	JournalFlag   []byte
+	LastFlag      []byte
+	CommittedFlag []byte
+	RolledBack    [][]byte
+	RemovedFiles  []string
+}
+
+// StatusReply Status接口的返回值
+type StatusReply struct {
+	LastFlag []byte
+	Recovery RecoveryReport
+}
+
 // DBApi db api
 type DBApi interface {
 	Close()
 	OpenFlag(flag []byte) error
+	OpenFlagOn(parent, flag []byte) error
+	GetOpenedFlags() [][]byte
 	GetLastFlag() []byte
 	Commit(flag []byte) error
 	Cancel(flag []byte) error
 	Rollback(flag []byte) error
+	RollbackTo(flag []byte) error
 	SetWithFlag(flag, tbName, key, value []byte) error
 	Set(tbName, key, value []byte) error
+	SetBatch(items []disk.BatchItem) error
+	SetWithFlagBatch(flag []byte, items []disk.BatchItem) error
+	CompareAndSet(tbName, key, expected, value []byte) (bool, error)
+	SetIfAbsent(tbName, key, value []byte) (bool, error)
+	SetWithTTL(tbName, key, value []byte, ttl time.Duration) error
+	Merge(tbName, key []byte, op string, operand []byte) ([]byte, error)
+	MergeWithFlag(flag, tbName, key []byte, op string, operand []byte) ([]byte, error)
+	Increment(tbName, key []byte, delta int64) (uint64, error)
+	IncrementWithFlag(flag, tbName, key []byte, delta int64) (uint64, error)
+	DeleteWithFlag(flag, tbName, key []byte) error
+	Delete(tbName, key []byte) error
 	Get(tbName, key []byte) []byte
+	GetMulti(keys []disk.TableKey) ([][]byte, []bool)
 	Exist(tbName, key []byte) bool
+	GetAt(flag, tbName, key []byte) ([]byte, error)
+	GetFlagChanges(flag []byte, offset, limit int) ([]disk.Change, int, error)
+	ChangesBetweenFrom(fromFlag, toFlag []byte, start *disk.TableKey, fn func(disk.Change) error) error
+	GetStateRoot(flag []byte) ([]byte, error)
+	GetWithProof(tbName, key []byte) ([]byte, *disk.Proof, error)
+	GetCommitHash(flag []byte) ([]byte, error)
+	VerifyChain() (int, []byte, error)
+	Prune() ([][]byte, error)
+	GetWithFlag(flag, tbName, key []byte) ([]byte, error)
+	ExistWithFlag(flag, tbName, key []byte) (bool, error)
+	ScanWithFlag(flag, tbName, start, end []byte, limit int, reverse bool) ([]disk.KV, []byte, error)
 	GetNextKey(tbName, preKey []byte) []byte
+	Scan(tbName, start, end []byte, limit int) ([]disk.KV, []byte)
+	ScanPrefix(tbName, prefix []byte, limit int) ([]disk.KV, []byte)
+	ScanReverse(tbName, start, end []byte, limit int) ([]disk.KV, []byte)
+	GetPrevKey(tbName, key []byte) []byte
+	GetLastKey(tbName []byte) []byte
+	RecoveryReport() disk.RecoveryReport
+	Savepoint(flag []byte) (uint64, error)
+	RollbackToSavepoint(flag []byte, id uint64) error
+	ReleaseSavepoint(flag []byte, id uint64) error
+	ListTables() [][]byte
+	TableStats(tbName []byte) disk.TableStat
+	DropTable(tbName []byte) error
+	CreateTable(tbName []byte, mode disk.TableMode) error
+	DropTableWithFlag(flag, tbName []byte) error
 }
 
 // DBFactory db factory
@@ -87,6 +364,24 @@ func RegisterAPI(t *TDb, factory DBFactory) {
 	t.factory = factory
 }
 
+// toKV convert the items of disk to the reply
+func toKV(items []disk.KV) []KV {
+	var out []KV
+	for _, it := range items {
+		out = append(out, KV(it))
+	}
+	return out
+}
+
+// toBatchItems convert the items of args to disk
+func toBatchItems(items []BatchItem) []disk.BatchItem {
+	out := make([]disk.BatchItem, len(items))
+	for i, it := range items {
+		out[i] = disk.BatchItem(it)
+	}
+	return out
+}
+
 func (t *TDb) getMgr(id uint64) DBApi {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -112,6 +407,84 @@ func (t *TDb) SetWithFlag(args *SetWithFlagArgs, reply *bool) error {
 	return dbm.SetWithFlag(args.Flag, args.TbName, args.Key, args.Value)
 }
 
+// SetBatch SetBatch
+func (t *TDb) SetBatch(args *SetBatchArgs, reply *bool) error {
+	dbm := t.getMgr(args.Chain)
+	return dbm.SetBatch(toBatchItems(args.Items))
+}
+
+// SetWithFlagBatch SetWithFlagBatch
+func (t *TDb) SetWithFlagBatch(args *SetBatchArgs, reply *bool) error {
+	dbm := t.getMgr(args.Chain)
+	return dbm.SetWithFlagBatch(args.Flag, toBatchItems(args.Items))
+}
+
+// SetWithTTL SetWithTTL
+func (t *TDb) SetWithTTL(args *SetWithTTLArgs, reply *bool) error {
+	dbm := t.getMgr(args.Chain)
+	return dbm.SetWithTTL(args.TbName, args.Key, args.Value, args.TTL)
+}
+
+// CompareAndSet CompareAndSet
+func (t *TDb) CompareAndSet(args *CompareAndSetArgs, reply *bool) error {
+	dbm := t.getMgr(args.Chain)
+	var err error
+	*reply, err = dbm.CompareAndSet(args.TbName, args.Key, args.Expected, args.Value)
+	return err
+}
+
+// SetIfAbsent SetIfAbsent
+func (t *TDb) SetIfAbsent(args *SetArgs, reply *bool) error {
+	dbm := t.getMgr(args.Chain)
+	var err error
+	*reply, err = dbm.SetIfAbsent(args.TbName, args.Key, args.Value)
+	return err
+}
+
+// Merge Merge
+func (t *TDb) Merge(args *MergeArgs, reply *([]byte)) error {
+	dbm := t.getMgr(args.Chain)
+	var err error
+	*reply, err = dbm.Merge(args.TbName, args.Key, args.Op, args.Operand)
+	return err
+}
+
+// MergeWithFlag MergeWithFlag
+func (t *TDb) MergeWithFlag(args *MergeArgs, reply *([]byte)) error {
+	dbm := t.getMgr(args.Chain)
+	var err error
+	*reply, err = dbm.MergeWithFlag(args.Flag, args.TbName, args.Key, args.Op, args.Operand)
+	return err
+}
+
+// Increment Increment
+func (t *TDb) Increment(args *IncrementArgs, reply *uint64) error {
+	dbm := t.getMgr(args.Chain)
+	var err error
+	*reply, err = dbm.Increment(args.TbName, args.Key, args.Delta)
+	return err
+}
+
+// IncrementWithFlag IncrementWithFlag
+func (t *TDb) IncrementWithFlag(args *IncrementArgs, reply *uint64) error {
+	dbm := t.getMgr(args.Chain)
+	var err error
+	*reply, err = dbm.IncrementWithFlag(args.Flag, args.TbName, args.Key, args.Delta)
+	return err
+}
+
+// Delete Delete
+func (t *TDb) Delete(args *GetArgs, reply *bool) error {
+	dbm := t.getMgr(args.Chain)
+	return dbm.Delete(args.TbName, args.Key)
+}
+
+// DeleteWithFlag DeleteWithFlag
+func (t *TDb) DeleteWithFlag(args *DeleteWithFlagArgs, reply *bool) error {
+	dbm := t.getMgr(args.Chain)
+	return dbm.DeleteWithFlag(args.Flag, args.TbName, args.Key)
+}
+
 // Get Get
 func (t *TDb) Get(args *GetArgs, reply *([]byte)) error {
 	dbm := t.getMgr(args.Chain)
@@ -119,6 +492,134 @@ func (t *TDb) Get(args *GetArgs, reply *([]byte)) error {
 	return nil
 }
 
+// GetMulti GetMulti
+func (t *TDb) GetMulti(args *GetMultiArgs, reply *GetMultiReply) error {
+	dbm := t.getMgr(args.Chain)
+	keys := make([]disk.TableKey, len(args.Keys))
+	for i, k := range args.Keys {
+		keys[i] = disk.TableKey(k)
+	}
+	reply.Values, reply.Found = dbm.GetMulti(keys)
+	return nil
+}
+
+// GetAt GetAt
+func (t *TDb) GetAt(args *GetWithFlagArgs, reply *([]byte)) error {
+	dbm := t.getMgr(args.Chain)
+	var err error
+	*reply, err = dbm.GetAt(args.Flag, args.TbName, args.Key)
+	return err
+}
+
+// GetFlagChanges GetFlagChanges
+func (t *TDb) GetFlagChanges(args *FlagChangesArgs, reply *FlagChangesReply) error {
+	dbm := t.getMgr(args.Chain)
+	items, next, err := dbm.GetFlagChanges(args.Flag, args.Offset, args.Limit)
+	for _, c := range items {
+		reply.Items = append(reply.Items, Change(c))
+	}
+	reply.Next = next
+	return err
+}
+
+// ChangesBetween get the net changes between the flags, from args.Start, at most args.Limit changes.
+// reply.Next is the key of the first change of the next page(nil: no more changes)
+func (t *TDb) ChangesBetween(args *ChangesBetweenArgs, reply *ChangesBetweenReply) error {
+	dbm := t.getMgr(args.Chain)
+	err := dbm.ChangesBetweenFrom(args.FromFlag, args.ToFlag, (*disk.TableKey)(args.Start), func(c disk.Change) error {
+		if args.Limit > 0 && len(reply.Items) >= args.Limit {
+			reply.Next = &TableKey{c.TbName, c.Key}
+			return errPageFull
+		}
+		reply.Items = append(reply.Items, Change(c))
+		return nil
+	})
+	if err == errPageFull {
+		return nil
+	}
+	return err
+}
+
+// GetStateRoot GetStateRoot
+func (t *TDb) GetStateRoot(args *FlagArgs, reply *([]byte)) error {
+	dbm := t.getMgr(args.Chain)
+	var err error
+	*reply, err = dbm.GetStateRoot(args.Flag)
+	return err
+}
+
+// GetWithProof GetWithProof
+func (t *TDb) GetWithProof(args *GetArgs, reply *ProofReply) error {
+	dbm := t.getMgr(args.Chain)
+	value, proof, err := dbm.GetWithProof(args.TbName, args.Key)
+	if err != nil {
+		return err
+	}
+	reply.Value = value
+	reply.Proof = Proof(*proof)
+	return nil
+}
+
+// GetCommitHash GetCommitHash
+func (t *TDb) GetCommitHash(args *FlagArgs, reply *([]byte)) error {
+	dbm := t.getMgr(args.Chain)
+	var err error
+	*reply, err = dbm.GetCommitHash(args.Flag)
+	return err
+}
+
+// VerifyChain VerifyChain
+func (t *TDb) VerifyChain(chain *uint64, reply *VerifyChainReply) error {
+	dbm := t.getMgr(*chain)
+	var err error
+	reply.Checked, reply.Mismatch, err = dbm.VerifyChain()
+	return err
+}
+
+// Prune Prune
+func (t *TDb) Prune(chain *uint64, reply *([][]byte)) error {
+	dbm := t.getMgr(*chain)
+	var err error
+	*reply, err = dbm.Prune()
+	return err
+}
+
+// ListTables ListTables
+func (t *TDb) ListTables(chain *uint64, reply *([][]byte)) error {
+	dbm := t.getMgr(*chain)
+	*reply = dbm.ListTables()
+	return nil
+}
+
+// TableStats TableStats
+func (t *TDb) TableStats(args *GetArgs, reply *TableStat) error {
+	dbm := t.getMgr(args.Chain)
+	st := dbm.TableStats(args.TbName)
+	reply.Mode = TableMode(st.Mode)
+	reply.Keys = st.Keys
+	reply.Bytes = st.Bytes
+	reply.Flagged = st.Flagged
+	return nil
+}
+
+// CreateTable CreateTable
+func (t *TDb) CreateTable(args *CreateTableArgs, reply *bool) error {
+	dbm := t.getMgr(args.Chain)
+	return dbm.CreateTable(args.TbName, disk.TableMode(args.Mode))
+}
+
+// DropTable DropTable
+func (t *TDb) DropTable(args *GetArgs, reply *bool) error {
+	dbm := t.getMgr(args.Chain)
+	return dbm.DropTable(args.TbName)
+}
+
+// DropTableWithFlag DropTableWithFlag
+func (t *TDb) DropTableWithFlag(args *DeleteWithFlagArgs, reply *bool) error {
+	dbm := t.getMgr(args.Chain)
+	return dbm.DropTableWithFlag(args.Flag, args.TbName)
+}
+
 // Exist Exist
 func (t *TDb) Exist(args *GetArgs, reply *bool) error {
 	dbm := t.getMgr(args.Chain)
@@ -132,6 +633,35 @@ func (t *TDb) OpenFlag(args *FlagArgs, reply *bool) error {
 	return dbm.OpenFlag(args.Flag)
 }
 
+// OpenFlagOn OpenFlagOn
+func (t *TDb) OpenFlagOn(args *OpenFlagOnArgs, reply *bool) error {
+	dbm := t.getMgr(args.Chain)
+	return dbm.OpenFlagOn(args.Parent, args.Flag)
+}
+
+// GetOpenedFlags GetOpenedFlags
+func (t *TDb) GetOpenedFlags(chain *uint64, reply *([][]byte)) error {
+	dbm := t.getMgr(*chain)
+	*reply = dbm.GetOpenedFlags()
+	return nil
+}
+
+// GetWithFlag GetWithFlag
+func (t *TDb) GetWithFlag(args *GetWithFlagArgs, reply *([]byte)) error {
+	dbm := t.getMgr(args.Chain)
+	var err error
+	*reply, err = dbm.GetWithFlag(args.Flag, args.TbName, args.Key)
+	return err
+}
+
+// ExistWithFlag ExistWithFlag
+func (t *TDb) ExistWithFlag(args *GetWithFlagArgs, reply *bool) error {
+	dbm := t.getMgr(args.Chain)
+	var err error
+	*reply, err = dbm.ExistWithFlag(args.Flag, args.TbName, args.Key)
+	return err
+}
+
 // CommitFlag CommitFlag
 func (t *TDb) CommitFlag(args *FlagArgs, reply *bool) error {
 	dbm := t.getMgr(args.Chain)
@@ -144,12 +674,38 @@ func (t *TDb) CancelFlag(args *FlagArgs, reply *bool) error {
 	return dbm.Cancel(args.Flag)
 }
 
+// Savepoint Savepoint
+func (t *TDb) Savepoint(args *FlagArgs, reply *uint64) error {
+	dbm := t.getMgr(args.Chain)
+	var err error
+	*reply, err = dbm.Savepoint(args.Flag)
+	return err
+}
+
+// RollbackToSavepoint RollbackToSavepoint
+func (t *TDb) RollbackToSavepoint(args *SavepointArgs, reply *bool) error {
+	dbm := t.getMgr(args.Chain)
+	return dbm.RollbackToSavepoint(args.Flag, args.ID)
+}
+
+// ReleaseSavepoint ReleaseSavepoint
+func (t *TDb) ReleaseSavepoint(args *SavepointArgs, reply *bool) error {
+	dbm := t.getMgr(args.Chain)
+	return dbm.ReleaseSavepoint(args.Flag, args.ID)
+}
+
 // Rollback Rollback
 func (t *TDb) Rollback(args *FlagArgs, reply *bool) error {
 	dbm := t.getMgr(args.Chain)
 	return dbm.Rollback(args.Flag)
 }
 
+// RollbackTo RollbackTo
+func (t *TDb) RollbackTo(args *FlagArgs, reply *bool) error {
+	dbm := t.getMgr(args.Chain)
+	return dbm.RollbackTo(args.Flag)
+}
+
 // GetLastFlag GetLastFlag
 func (t *TDb) GetLastFlag(chain *uint64, reply *([]byte)) error {
 	dbm := t.getMgr(*chain)
@@ -157,9 +713,57 @@ func (t *TDb) GetLastFlag(chain *uint64, reply *([]byte)) error {
 	return nil
 }
 
+// Status get the last flag and the recovery report of the chain
+func (t *TDb) Status(chain *uint64, reply *StatusReply) error {
+	dbm := t.getMgr(*chain)
+	reply.LastFlag = dbm.GetLastFlag()
+	reply.Recovery = RecoveryReport(dbm.RecoveryReport())
+	return nil
+}
+
 // GetNextKey GetNextKey
 func (t *TDb) GetNextKey(args *GetArgs, reply *([]byte)) error {
 	dbm := t.getMgr(args.Chain)
 	*reply = dbm.GetNextKey(args.TbName, args.Key)
 	return nil
 }
+
+// GetPrevKey GetPrevKey
+func (t *TDb) GetPrevKey(args *GetArgs, reply *([]byte)) error {
+	dbm := t.getMgr(args.Chain)
+	*reply = dbm.GetPrevKey(args.TbName, args.Key)
+	return nil
+}
+
+// GetLastKey GetLastKey,args.Key is not used
+func (t *TDb) GetLastKey(args *GetArgs, reply *([]byte)) error {
+	dbm := t.getMgr(args.Chain)
+	*reply = dbm.GetLastKey(args.TbName)
+	return nil
+}
+
+// Scan Scan
+func (t *TDb) Scan(args *ScanArgs, reply *ScanReply) error {
+	dbm := t.getMgr(args.Chain)
+	var items []disk.KV
+	var err error
+	switch {
+	case len(args.Flag) > 0:
+		items, reply.Next, err = dbm.ScanWithFlag(args.Flag, args.TbName, args.Start, args.End, args.Limit, args.Reverse)
+	case args.Reverse:
+		items, reply.Next = dbm.ScanReverse(args.TbName, args.Start, args.End, args.Limit)
+	default:
+		items, reply.Next = dbm.Scan(args.TbName, args.Start, args.End, args.Limit)
+	}
+	reply.Items = toKV(items)
+	return err
+}
+
+// ScanPrefix ScanPrefix
+func (t *TDb) ScanPrefix(args *ScanPrefixArgs, reply *ScanReply) error {
+	dbm := t.getMgr(args.Chain)
+	var items []disk.KV
+	items, reply.Next = dbm.ScanPrefix(args.TbName, args.Prefix, args.Limit)
+	reply.Items = toKV(items)
+	return nil
+}
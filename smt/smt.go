@@ -0,0 +1,114 @@
+// Package smt the hashing of the sparse merkle tree and the proof of a key,
+// it is shared by the database(disk) and the client, without other dependency.
+//
+// The path of a key is sha256(len(tbName)+tbName+key), a subtree with only one leaf is
+// replaced by the leaf, an empty subtree is not stored and its hash is zero.
+// leaf hash: sha256(0x00+path+sha256(value)), node hash: sha256(0x01+left+right).
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// Depth the depth of the tree, the bits of the path
+const Depth = sha256.Size * 8
+
+// the types of node
+const (
+	Leaf = iota
+	Inner
+)
+
+// Empty the hash of the empty subtree
+var Empty = make([]byte, sha256.Size)
+
+// Proof the proof of a key, verify it by Verify
+type Proof struct {
+	// Flag the last committed flag
+	Flag []byte
+	// Root the state root of Flag
+	Root []byte
+	// Siblings the hashes of the siblings, from the root to the leaf
+	Siblings [][]byte
+	// LeafPath and LeafValueHash the leaf found at the position of the key,
+	// it is another key if the key not exist(empty if the position is empty)
+	LeafPath      []byte
+	LeafValueHash []byte
+}
+
+// Path return the path of the key
+func Path(tbName, key []byte) []byte {
+	l := make([]byte, 8)
+	binary.BigEndian.PutUint64(l, uint64(len(tbName)))
+	h := sha256.New()
+	h.Write(l)
+	h.Write(tbName)
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// ValueHash return the hash of the value
+func ValueHash(value []byte) []byte {
+	h := sha256.Sum256(value)
+	return h[:]
+}
+
+// LeafHash return the hash of the leaf
+func LeafHash(path, valueHash []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{Leaf})
+	h.Write(path)
+	h.Write(valueHash)
+	return h.Sum(nil)
+}
+
+// InnerHash return the hash of the inner node
+func InnerHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{Inner})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Bit return the bit of path at depth, 0 or 1
+func Bit(path []byte, depth int) int {
+	return int(path[depth/8]>>(7-uint(depth%8))) & 1
+}
+
+// Verify return true if the value(empty: not exist) of the key is proved by the proof and root
+func Verify(root, tbName, key, value []byte, proof *Proof) bool {
+	if proof == nil || len(proof.Siblings) > Depth {
+		return false
+	}
+	path := Path(tbName, key)
+	h := Empty
+	if len(value) > 0 {
+		if bytes.Compare(proof.LeafPath, path) != 0 ||
+			bytes.Compare(proof.LeafValueHash, ValueHash(value)) != 0 {
+			return false
+		}
+		h = LeafHash(path, proof.LeafValueHash)
+	} else if len(proof.LeafPath) > 0 {
+		if len(proof.LeafPath) != sha256.Size || bytes.Compare(proof.LeafPath, path) == 0 {
+			return false
+		}
+		// the leaf must be at the position of the key
+		for depth := range proof.Siblings {
+			if Bit(proof.LeafPath, depth) != Bit(path, depth) {
+				return false
+			}
+		}
+		h = LeafHash(proof.LeafPath, proof.LeafValueHash)
+	}
+	for depth := len(proof.Siblings) - 1; depth >= 0; depth-- {
+		if Bit(path, depth) == 0 {
+			h = InnerHash(h, proof.Siblings[depth])
+		} else {
+			h = InnerHash(proof.Siblings[depth], h)
+		}
+	}
+	return bytes.Compare(h, root) == 0
+}
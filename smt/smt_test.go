@@ -0,0 +1,32 @@
+package smt
+
+import (
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	tbName := []byte("table1")
+	key := []byte("key1")
+	value := []byte("value1")
+	if !Verify(Empty, tbName, key, nil, &Proof{}) {
+		t.Error("hope the key not exist in the empty tree")
+	}
+	if Verify(Empty, tbName, key, value, &Proof{}) || Verify(Empty, tbName, key, nil, nil) {
+		t.Error("hope fail to verify the value in the empty tree")
+	}
+
+	// the tree with only one leaf, the root is the leaf
+	path := Path(tbName, key)
+	root := LeafHash(path, ValueHash(value))
+	proof := &Proof{LeafPath: path, LeafValueHash: ValueHash(value)}
+	if !Verify(root, tbName, key, value, proof) {
+		t.Error("fail to verify the leaf")
+	}
+	if Verify(root, tbName, key, []byte("value2"), proof) {
+		t.Error("hope fail to verify the other value")
+	}
+	key2 := []byte("key2")
+	if !Verify(root, tbName, key2, nil, proof) || Verify(root, tbName, key2, value, proof) {
+		t.Error("hope the other key not exist")
+	}
+}